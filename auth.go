@@ -0,0 +1,134 @@
+package mrs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrUnauthorized is the message when a request which needs the identity of the
+	// caller is anonymous.
+	ErrUnauthorized = errors.New("sorry: you need to sign in first")
+
+	// ErrForbidden is the message when the caller is not allowed to modify a profile.
+	ErrForbidden = errors.New("sorry: you are not allowed to modify this profile")
+)
+
+// RoleAdmin is the role given to identities which can modify any profile.
+const RoleAdmin = "admin"
+
+// Identity is the caller of a request.
+type Identity struct {
+	// UserID is the id of the profile of the caller.
+	UserID string
+	Roles  []string
+}
+
+// HasRole reports whether the identity has the given role.
+func (i *Identity) HasRole(role string) bool {
+	for _, v := range i.Roles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the identity of the caller from the request. Anonymous
+// requests have a nil identity and a nil error, the error is for requests carrying
+// bad credentials.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthenticatorFunc is an adapter which allows ordinary functions to be used as
+// Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// Policy decides whether an identity is allowed to modify a profile.
+type Policy interface {
+	CanModify(who *Identity, p *Profile) bool
+}
+
+// PolicyFunc is an adapter which allows ordinary functions to be used as Policy.
+type PolicyFunc func(who *Identity, p *Profile) bool
+
+// CanModify calls f(who, p).
+func (f PolicyFunc) CanModify(who *Identity, p *Profile) bool {
+	return f(who, p)
+}
+
+// OwnerPolicy allows only the owner of a profile and identities with the admin role to
+// modify it. The owner is the user whose id is the profile id, or who created the
+// profile when it was created with Handlers.Create.
+var OwnerPolicy Policy = PolicyFunc(func(who *Identity, p *Profile) bool {
+	if who == nil {
+		return false
+	}
+	return who.UserID == p.ID || (p.Owner != "" && who.UserID == p.Owner) || who.HasRole(RoleAdmin)
+})
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, who *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, who)
+}
+
+// IdentityFrom returns the identity stored in ctx by WithIdentity, or nil.
+func IdentityFrom(ctx context.Context) *Identity {
+	who, _ := ctx.Value(identityKey{}).(*Identity)
+	return who
+}
+
+// identity resolves the caller of the request. An identity already stored in the
+// request context, for instance by a middleware, takes precedence over the
+// Authenticator of the handlers.
+func (h *Handlers) identity(r *http.Request) (*Identity, error) {
+	if who := IdentityFrom(r.Context()); who != nil {
+		return who, nil
+	}
+	if h.Auth == nil {
+		return nil, nil
+	}
+	return h.Auth.Authenticate(r)
+}
+
+// authenticate resolves the caller of a request which modifies data. When the handlers
+// have no Authenticator, every request is allowed and the identity is nil. Otherwise
+// anonymous callers are rejected with 401 Unauthorized and false is returned.
+func (h *Handlers) authenticate(w http.ResponseWriter, r *http.Request, format string) (*Identity, bool) {
+	if h.Auth == nil && IdentityFrom(r.Context()) == nil {
+		return nil, true
+	}
+	who, err := h.identity(r)
+	if err != nil || who == nil {
+		h.renderError(w, format, newError(http.StatusUnauthorized, CodeUnauthorized, ErrUnauthorized))
+		return nil, false
+	}
+	return who, true
+}
+
+// authorize checks with the Policy that who can modify p, if not it responds with
+// 403 Forbidden and returns false. Like authenticate, everything is allowed when the
+// handlers have no Authenticator.
+func (h *Handlers) authorize(w http.ResponseWriter, r *http.Request, format string, who *Identity, p *Profile) bool {
+	if who == nil {
+		return true
+	}
+	policy := h.Policy
+	if policy == nil {
+		policy = OwnerPolicy
+	}
+	if !policy.CanModify(who, p) {
+		h.renderError(w, format, newError(http.StatusForbidden, CodeForbidden, ErrForbidden))
+		return false
+	}
+	return true
+}
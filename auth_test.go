@@ -0,0 +1,105 @@
+package mrs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// headerAuth trusts the X-User and X-Role headers, good enough for tests.
+var headerAuth = AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+	user := r.Header.Get("X-User")
+	if user == "" {
+		return nil, nil
+	}
+	who := &Identity{UserID: user}
+	if role := r.Header.Get("X-Role"); role != "" {
+		who.Roles = append(who.Roles, role)
+	}
+	return who, nil
+})
+
+func TestHandlers_Auth(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/update/{id}", handle.Update)
+	h.HandleFunc("/profile/picture/{id}", handle.ProfilePic)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []struct {
+		user, role string
+		code       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{pids[1], "", http.StatusForbidden},
+		{pids[1], "moderator", http.StatusForbidden},
+		{pids[0], "", http.StatusOK},
+		{pids[1], RoleAdmin, http.StatusOK},
+	}
+	for _, v := range sample {
+		body := strings.NewReader(`{"city":"mwanza"}`)
+		r, _ := http.NewRequest("PUT", fmt.Sprintf("/profile/update/%s", pids[0]), body)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("If-Match", "*")
+		r.Header.Set("X-User", v.user)
+		r.Header.Set("X-Role", v.role)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s %s: expected %d actual %d", v.user, v.role, v.code, w.Code)
+		}
+	}
+
+	// uploads are checked before the body is read
+	r := ajaxtWithFile(fmt.Sprintf("/profile/picture/%s", pids[0]), "profile", t)
+	r.Header.Set("If-Match", "*")
+	r.Header.Set("X-User", pids[2])
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), CodeForbidden) {
+		t.Errorf("Expected %s to contain %s", w.Body.String(), CodeForbidden)
+	}
+}
+
+func TestHandlers_CreateOwner(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	defer cleanUp()
+
+	r, _ := http.NewRequest("POST", "/profile", strings.NewReader(""))
+	w := httptest.NewRecorder()
+	handle.Create(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d actual %d", http.StatusUnauthorized, w.Code)
+	}
+
+	r, _ = http.NewRequest("POST", "/profile", strings.NewReader(""))
+	r.Header.Set("X-User", "gernest")
+	w = httptest.NewRecorder()
+	handle.Create(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected %d actual %d", http.StatusCreated, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"owner":"gernest"`) {
+		t.Errorf("Expected %s to contain the owner", w.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+package mrs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CachePolicy holds the values of the Cache-Control header sent along with the
+// responses of the handlers.
+type CachePolicy struct {
+	// Profile is used for the profile home page, both html and json. Profiles
+	// change often, so this should be short lived.
+	Profile string
+
+	// Photo is used for the data of public photos. Photos are never modified once
+	// saved, but they can still be rejected by moderators or made private, so by
+	// default caches have to revalidate them with the ETag before every use.
+	Photo string
+
+	// Rendition is used for the renditions of public photos whose url pins the crop
+	// revision, the data behind such a url never changes so it can be cached forever.
+	// The renditions of the current crop, without the revision in the url, are
+	// revalidated like the photos.
+	Rendition string
+}
+
+// DefaultCachePolicy is the CachePolicy used by handlers created with NewHandlers.
+var DefaultCachePolicy = CachePolicy{
+	Profile:   "private, max-age=60",
+	Photo:     "public, no-cache",
+	Rendition: "public, max-age=31536000, immutable",
+}
+
+// contentETag returns a strong entity tag computed from the hash of data.
+func contentETag(data ...[]byte) string {
+	h := sha256.New()
+	for _, v := range data {
+		h.Write(v)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil)[:16])
+}
+
+// readerETag is like contentETag but reads the data from r, which is rewound once the
+// hash has been computed.
+func readerETag(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil)[:16]), nil
+}
+
+// photoETag returns the entity tag of the photo data. It is the same as the one from
+// readerETag, but the hash recorded with the photo is used when there is one, so the
+// data doesn't have to be read.
+func photoETag(photo *Photo, data io.ReadSeeker) (string, error) {
+	if len(photo.Hash) >= 32 {
+		return "\"" + photo.Hash[:32] + "\"", nil
+	}
+	return readerETag(data)
+}
+
+// renditionETag returns the entity tag of a rendition of the photo. Since the
+// renditions of a crop revision never change, it is derived from the hash recorded
+// with the photo when there is one, instead of hashing the data on every request.
+func renditionETag(photo *Photo, rendition string, revision int, data io.ReadSeeker) (string, error) {
+	if rendition == RenditionOriginal || len(photo.Hash) < 32 {
+		return photoETag(photo, data)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", photo.Hash, rendition, revision)))
+	return fmt.Sprintf("\"%x\"", sum[:16]), nil
+}
+
+// notModified sets the validators etag and modified on the response, and checks
+// the conditional headers of the request against them. It returns true if the
+// client already has a fresh copy, in which case 304 Not Modified has already been
+// written and the caller should not write a body.
+//
+// As required by rfc 7232, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	fresh := false
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		fresh = etag != "" && matchETag(match, etag, true)
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		fresh = err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	if fresh {
+		h := w.Header()
+		delete(h, "Content-Type")
+		delete(h, "Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return fresh
+}
+
+// matchETag reports whether etag is in the comma separated list of entity tags
+// given in header. A weak comparison ignores the W/ prefix, and is what should be
+// used for If-None-Match, If-Match requires a strong comparison.
+func matchETag(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if weak {
+			v = strings.TrimPrefix(v, "W/")
+		}
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package mrs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultClamChunkSize is the size of the chunks streamed to clamd, well below the
+	// StreamMaxLength of its default configuration.
+	defaultClamChunkSize = 64 << 10
+
+	defaultClamTimeout = 30 * time.Second
+)
+
+// ClamAV is a Scanner which sends uploads to a clamd daemon, with the INSTREAM
+// command of the clamd protocol. Files with a virus are rejected, with the name of
+// the virus as the reason, and the others are approved.
+type ClamAV struct {
+	// Network and Addr are where clamd listens, like tcp and localhost:3310, or unix
+	// and /var/run/clamav/clamd.ctl.
+	Network string
+	Addr    string
+
+	// Timeout bounds the whole scan of a file, connecting included.
+	Timeout time.Duration
+
+	// ChunkSize is the size of the chunks the file is streamed in.
+	ChunkSize int
+}
+
+// NewClamAV returns a ClamAV scanner talking to clamd over tcp at addr.
+func NewClamAV(addr string) *ClamAV {
+	return &ClamAV{
+		Network:   "tcp",
+		Addr:      addr,
+		Timeout:   defaultClamTimeout,
+		ChunkSize: defaultClamChunkSize,
+	}
+}
+
+// Ping checks that clamd is up.
+func (c *ClamAV) Ping() error {
+	reply, err := c.command("zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// Scan streams data to clamd and returns its verdict.
+func (c *ClamAV) Scan(photo *Photo, data io.Reader) (*Verdict, error) {
+	reply, err := c.command("zINSTREAM\x00", data)
+	if err != nil {
+		return nil, err
+	}
+	// the reply is "stream: OK", "stream: <virus> FOUND" or "<message> ERROR".
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return &Verdict{Status: StatusApproved}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Verdict{Status: StatusRejected, Reason: strings.TrimSuffix(reply, " FOUND")}, nil
+	}
+	return nil, fmt.Errorf("clamd: %s", reply)
+}
+
+// command sends cmd to clamd, followed by data in chunks if it is not nil, and returns
+// the reply.
+func (c *ClamAV) command(cmd string, data io.Reader) (string, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultClamTimeout
+	}
+	conn, err := net.DialTimeout(c.Network, c.Addr, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	w := bufio.NewWriter(conn)
+	w.WriteString(cmd)
+	if data != nil {
+		if err = c.stream(w, data); err != nil {
+			return "", err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// stream writes data as chunks prefixed by their length, ended by an empty chunk. Errors
+// writing to w are left to its Flush.
+func (c *ClamAV) stream(w *bufio.Writer, data io.Reader) error {
+	size := c.ChunkSize
+	if size <= 0 {
+		size = defaultClamChunkSize
+	}
+	buf := make([]byte, size)
+	var prefix [4]byte
+	for {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(prefix[:], uint32(n))
+			w.Write(prefix[:])
+			w.Write(buf[:n])
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
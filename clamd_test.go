@@ -0,0 +1,101 @@
+package mrs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeClamd serves the PING and INSTREAM commands of clamd, streams containing the
+// EICAR marker are reported as infected.
+func fakeClamd(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+				switch cmd {
+				case "zPING\x00":
+					io.WriteString(conn, "PONG\x00")
+				case "zINSTREAM\x00":
+					data := new(bytes.Buffer)
+					for {
+						var size uint32
+						if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+						if size > 1<<20 {
+							io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+							return
+						}
+						if _, err := io.CopyN(data, r, int64(size)); err != nil {
+							return
+						}
+					}
+					if strings.Contains(data.String(), "EICAR") {
+						io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+						return
+					}
+					io.WriteString(conn, "stream: OK\x00")
+				default:
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+				}
+			}(conn)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestClamAV(t *testing.T) {
+	addr, stop := fakeClamd(t)
+	defer stop()
+
+	c := NewClamAV(addr)
+	c.ChunkSize = 7
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		data   string
+		status PhotoStatus
+		reason string
+	}{
+		{"", StatusApproved, ""},
+		{"a perfectly fine photo", StatusApproved, ""},
+		{"X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*", StatusRejected, "Eicar-Test-Signature"},
+	}
+	for _, v := range sample {
+		verdict, err := c.Scan(&Photo{}, strings.NewReader(v.data))
+		if err != nil {
+			t.Errorf("%q: %v", v.data, err)
+			continue
+		}
+		if verdict.Status != v.status || verdict.Reason != v.reason {
+			t.Errorf("%q: expected %s %q got %s %q", v.data, v.status, v.reason, verdict.Status, verdict.Reason)
+		}
+	}
+
+	stop()
+	if _, err := c.Scan(&Photo{}, strings.NewReader("data")); err == nil {
+		t.Error("expected an error when clamd is down")
+	}
+}
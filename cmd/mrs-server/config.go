@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is the configuration of the server, see the package documentation for the
+// meaning of the keys.
+type config struct {
+	Listen          string
+	DataDir         string
+	Templates       string
+	IDs             string
+	ShutdownTimeout time.Duration
+
+	DB         string
+	Meta       string
+	Data       string
+	Usage      string
+	Hashes     string
+	Moderation string
+	Reports    string
+
+	MaxUploadSize int64
+	MaxPhotos     int
+	MaxBytes      int64
+	MaxWidth      int
+	MaxHeight     int
+	MaxPixels     int
+
+	Update      time.Duration
+	ProfilePic  time.Duration
+	FileUploads time.Duration
+	Report      time.Duration
+	Burst       int
+
+	CSRF          bool
+	SecureCookies bool
+	SessionKeys   string
+	SigningKeys   string
+	Clamd         string
+}
+
+func defaultConfig() *config {
+	return &config{
+		Listen:          ":8080",
+		DataDir:         ".",
+		Templates:       "templates",
+		IDs:             "uuidv4",
+		ShutdownTimeout: 30 * time.Second,
+
+		DB:         "imgs.db",
+		Meta:       "meta",
+		Data:       "data",
+		Usage:      "usage",
+		Hashes:     "hashes",
+		Moderation: "moderation",
+		Reports:    "reports",
+
+		MaxUploadSize: 128 << 20,
+		MaxWidth:      16384,
+		MaxHeight:     16384,
+		MaxPixels:     50000000,
+
+		Burst: 5,
+
+		CSRF:          true,
+		SecureCookies: true,
+	}
+}
+
+// keys maps the keys of the config file to the fields they set. A key is written as
+// table.name in the file, and as MRS_TABLE_NAME in the environment.
+func (c *config) keys() map[string]interface{} {
+	return map[string]interface{}{
+		"listen":           &c.Listen,
+		"data_dir":         &c.DataDir,
+		"templates":        &c.Templates,
+		"ids":              &c.IDs,
+		"shutdown_timeout": &c.ShutdownTimeout,
+
+		"buckets.db":         &c.DB,
+		"buckets.meta":       &c.Meta,
+		"buckets.data":       &c.Data,
+		"buckets.usage":      &c.Usage,
+		"buckets.hashes":     &c.Hashes,
+		"buckets.moderation": &c.Moderation,
+		"buckets.reports":    &c.Reports,
+
+		"limits.max_upload_size": &c.MaxUploadSize,
+		"limits.max_photos":      &c.MaxPhotos,
+		"limits.max_bytes":       &c.MaxBytes,
+		"limits.max_width":       &c.MaxWidth,
+		"limits.max_height":      &c.MaxHeight,
+		"limits.max_pixels":      &c.MaxPixels,
+
+		"rate.update":       &c.Update,
+		"rate.profile_pic":  &c.ProfilePic,
+		"rate.file_uploads": &c.FileUploads,
+		"rate.report":       &c.Report,
+		"rate.burst":        &c.Burst,
+
+		"security.csrf":           &c.CSRF,
+		"security.secure_cookies": &c.SecureCookies,
+		"security.session_keys":   &c.SessionKeys,
+		"security.signing_keys":   &c.SigningKeys,
+		"security.clamd":          &c.Clamd,
+	}
+}
+
+// set parses value into the field of key.
+func (c *config) set(key, value string) error {
+	field, ok := c.keys()[key]
+	if !ok {
+		return fmt.Errorf("unknown key %s", key)
+	}
+	var err error
+	switch v := field.(type) {
+	case *string:
+		*v = value
+	case *int:
+		*v, err = strconv.Atoi(value)
+	case *int64:
+		*v, err = strconv.ParseInt(value, 10, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(value)
+	case *time.Duration:
+		*v, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: bad value %q", key, value)
+	}
+	return nil
+}
+
+// loadConfig reads the config file at path, if path is not empty, then applies the
+// environment on top of it.
+func loadConfig(path string) (*config, error) {
+	c := defaultConfig()
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.readEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readFile reads a config file written in the subset of TOML made of tables, and of
+// keys with string, integer or boolean values.
+func (c *config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	table := ""
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return fmt.Errorf("%s:%d: bad table", path, n)
+			}
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != '#' {
+				return fmt.Errorf("%s:%d: bad table", path, n)
+			}
+			table = strings.TrimSpace(line[1:end])
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key := strings.TrimSpace(line[:i])
+		if table != "" {
+			key = table + "." + key
+		}
+		value, err := tomlValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if err = c.set(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return s.Err()
+}
+
+// tomlValue returns the value with its quotes and trailing comment removed.
+func tomlValue(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	switch v[0] {
+	case '"':
+		end := 1
+		for ; end < len(v) && v[end] != '"'; end++ {
+			if v[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(v) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %s", rest)
+		}
+		return strconv.Unquote(v[:end+1])
+	case '\'':
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(v[end+2:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %s", rest)
+		}
+		return v[1 : end+1], nil
+	}
+	if i := strings.IndexByte(v, '#'); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return strings.Replace(v, "_", "", -1), nil
+}
+
+// readEnv sets the keys found in the environment.
+func (c *config) readEnv(lookup func(string) (string, bool)) error {
+	var keys []string
+	for k := range c.keys() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := "MRS_" + strings.ToUpper(strings.Replace(k, ".", "_", -1))
+		if v, ok := lookup(name); ok {
+			if err := c.set(k, v); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
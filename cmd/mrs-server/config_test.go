@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, s string) string {
+	path := filepath.Join(t.TempDir(), "mrs.toml")
+	if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig_readFile(t *testing.T) {
+	path := writeConfig(t, `
+# the server
+listen = "127.0.0.1:9000"   # inline comment
+data_dir = '/var/lib/mrs'
+templates = "tmpl # not a comment"
+ids = "ulid"
+shutdown_timeout = "5s"
+
+[limits]
+max_upload_size = 1_048_576 # a megabyte
+max_photos = 10
+
+[rate]
+update = "1m30s"
+burst = 2
+
+[ security ]
+csrf = false
+session_keys = "a\"b,c"
+`)
+	c := defaultConfig()
+	if err := c.readFile(path); err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		key            string
+		actual, expect interface{}
+	}{
+		{"listen", c.Listen, "127.0.0.1:9000"},
+		{"data_dir", c.DataDir, "/var/lib/mrs"},
+		{"templates", c.Templates, "tmpl # not a comment"},
+		{"ids", c.IDs, "ulid"},
+		{"shutdown_timeout", c.ShutdownTimeout, 5 * time.Second},
+		{"limits.max_upload_size", c.MaxUploadSize, int64(1 << 20)},
+		{"limits.max_photos", c.MaxPhotos, 10},
+		{"limits.max_width", c.MaxWidth, 16384},
+		{"rate.update", c.Update, 90 * time.Second},
+		{"rate.burst", c.Burst, 2},
+		{"security.csrf", c.CSRF, false},
+		{"security.secure_cookies", c.SecureCookies, true},
+		{"security.session_keys", c.SessionKeys, `a"b,c`},
+	}
+	for _, v := range sample {
+		if v.actual != v.expect {
+			t.Errorf("%s: expected %v got %v", v.key, v.expect, v.actual)
+		}
+	}
+}
+
+func TestConfig_readFileErrors(t *testing.T) {
+	sample := []struct {
+		file, err string
+	}{
+		{"colour = \"red\"\n", "mrs.toml:1: unknown key colour"},
+		{"[limits]\nlisten = \":80\"\n", "mrs.toml:2: unknown key limits.listen"},
+		{"listen\n", "mrs.toml:1: expected key = value"},
+		{"listen =\n", "mrs.toml:1: missing value"},
+		{"listen = \":80\n", "mrs.toml:1: unterminated string"},
+		{"listen = ':80\n", "mrs.toml:1: unterminated string"},
+		{"listen = \":80\" \":81\"\n", "mrs.toml:1: unexpected \":81\""},
+		{"[limits\n", "mrs.toml:1: bad table"},
+		{"[limits] max_photos = 1\n", "mrs.toml:1: bad table"},
+		{"\n[limits]\nmax_photos = ten\n", "mrs.toml:3: limits.max_photos: bad value \"ten\""},
+		{"[security]\ncsrf = maybe\n", "mrs.toml:2: security.csrf: bad value \"maybe\""},
+		{"shutdown_timeout = \"30\"\n", "mrs.toml:1: shutdown_timeout: bad value \"30\""},
+	}
+	for _, v := range sample {
+		err := defaultConfig().readFile(writeConfig(t, v.file))
+		if err == nil {
+			t.Errorf("%q: expected an error", v.file)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), v.err) {
+			t.Errorf("%q: expected %s got %v", v.file, v.err, err)
+		}
+	}
+}
+
+func TestConfig_readEnv(t *testing.T) {
+	c := defaultConfig()
+	if err := c.readFile(writeConfig(t, "listen = \":9000\"\n[limits]\nmax_photos = 10\nmax_bytes = 100\n")); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"MRS_LISTEN":             ":9001",
+		"MRS_LIMITS_MAX_PHOTOS":  "20",
+		"MRS_SECURITY_CSRF":      "false",
+		"MRS_RATE_REPORT":        "1h",
+		"MRS_SOMETHING_ELSE_YET": "ignored",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	if err := c.readEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":9001" || c.MaxPhotos != 20 || c.CSRF || c.Report != time.Hour {
+		t.Errorf("expected the environment to take precedence, got %+v", c)
+	}
+	if c.MaxBytes != 100 {
+		t.Errorf("expected max_bytes from the file got %d", c.MaxBytes)
+	}
+
+	env = map[string]string{"MRS_LIMITS_MAX_PHOTOS": "many"}
+	err := c.readEnv(lookup)
+	if err == nil || !strings.HasPrefix(err.Error(), "MRS_LIMITS_MAX_PHOTOS:") {
+		t.Errorf("expected an error naming the variable got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "listen = \":9000\"\nids = \"uuidv7\"\n")
+	t.Setenv("MRS_LISTEN", ":9001")
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":9001" || c.IDs != "uuidv7" {
+		t.Errorf("expected :9001 and uuidv7 got %s and %s", c.Listen, c.IDs)
+	}
+	if _, err = loadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
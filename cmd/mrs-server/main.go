@@ -0,0 +1,212 @@
+// Command mrs-server serves the handlers of the mrs package over http.
+//
+// Usage:
+//
+//	mrs-server [-config mrs.toml]
+//
+// The config file is written in the subset of TOML made of tables, and of keys with
+// string, integer or boolean values. Every key can also be set in the environment as
+// MRS_ followed by the table and the key in upper case, like MRS_LIMITS_MAX_PHOTOS,
+// which takes precedence over the file. The keys and their defaults are:
+//
+//	listen = ":8080"
+//	data_dir = "."            # where the db directory of the profiles lives
+//	templates = "templates"   # holds profile_home.tmpl
+//	ids = "uuidv4"            # the profile ids, uuidv4, uuidv7 or ulid
+//	shutdown_timeout = "30s"
+//
+//	[buckets]
+//	db = "imgs.db"            # the photo database, relative to data_dir
+//	meta = "meta"
+//	data = "data"
+//	usage = "usage"
+//	hashes = "hashes"
+//	moderation = "moderation"
+//	reports = "reports"
+//
+//	[limits]
+//	max_upload_size = 134217728
+//	max_photos = 0            # per profile, 0 means no limit
+//	max_bytes = 0             # per profile, 0 means no limit
+//	max_width = 16384
+//	max_height = 16384
+//	max_pixels = 50000000
+//
+//	[rate]
+//	update = ""               # one request every interval, like "10s", per profile
+//	profile_pic = ""          # and client, empty means no limit
+//	file_uploads = ""
+//	report = ""
+//	burst = 5
+//
+//	[security]
+//	csrf = true
+//	secure_cookies = true
+//	session_keys = ""         # comma separated, the first one is current
+//	signing_keys = ""         # comma separated, the first one is current
+//	clamd = ""                # the address of clamd to scan uploads with
+//
+// Without session keys there is no authentication, anyone can modify any profile, and
+// the moderation routes are not registered. With them, callers are resolved from the
+// session cookies issued by the application sharing the keys.
+//
+// The routes are:
+//
+//	/profile                        Create
+//	/profile/{id}                   Home
+//	/profile/update/{id}            Update
+//	/profile/picture/{id}           ProfilePic
+//	/profile/crop/{id}              Crop
+//	/profile/uploads/{id}           FileUploads
+//	/profile/usage/{id}             Usage
+//	/profile/{id}/report            Report
+//	/photo/{id}                     Photo
+//	/moderation                     ModerationQueue, with session keys only
+//	/moderation/{id}/approve        ApprovePhoto, with session keys only
+//	/moderation/{id}/reject         RejectPhoto, with session keys only
+//	/photo/{id}/similar             SimilarPhotos, with session keys only
+//	/reports                        Reports, with session keys only
+//
+// On SIGINT or SIGTERM the server stops accepting connections and waits up to
+// shutdown_timeout for the requests in flight. The bolt databases are only open
+// while a request uses them, so once the requests are done they are all closed.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gernest/mrs"
+	"github.com/unrolled/render"
+)
+
+func main() {
+	path := flag.String("config", "", "the config file")
+	flag.Parse()
+
+	c, err := loadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h, err := newServer(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = serve(c, h); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newServer builds the handler of the server from the config. It changes the working
+// directory to the data directory, which is where the profiles are stored.
+func newServer(c *config) (http.Handler, error) {
+	formats := map[string]mrs.IDScheme{
+		"uuidv4": mrs.UUIDv4,
+		"uuidv7": mrs.UUIDv7,
+		"ulid":   mrs.ULID,
+	}
+	ids, ok := formats[c.IDs]
+	if !ok {
+		return nil, fmt.Errorf("unknown id format %s", c.IDs)
+	}
+	if err := os.Chdir(c.DataDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll("db", 0700); err != nil {
+		return nil, err
+	}
+
+	h := mrs.NewHandlers(c.DB, c.Meta, c.Data, &render.Options{Directory: c.Templates})
+	h.IDFormat, h.IDs = ids, ids
+	h.MaxUploadSize = c.MaxUploadSize
+	h.RateLimits.Update = mrs.Every(c.Update, c.Burst)
+	h.RateLimits.ProfilePic = mrs.Every(c.ProfilePic, c.Burst)
+	h.RateLimits.FileUploads = mrs.Every(c.FileUploads, c.Burst)
+	h.RateLimits.Report = mrs.Every(c.Report, c.Burst)
+
+	pm := h.Photos()
+	pm.UsageBucket = c.Usage
+	pm.HashBucket = c.Hashes
+	pm.ModerationBucket = c.Moderation
+	pm.ReportBucket = c.Reports
+	pm.Quota = mrs.Quota{MaxPhotos: c.MaxPhotos, MaxBytes: c.MaxBytes}
+	pm.Limits = mrs.DecodeLimits{MaxWidth: c.MaxWidth, MaxHeight: c.MaxHeight, MaxPixels: c.MaxPixels}
+	if c.Clamd != "" {
+		clam := mrs.NewClamAV(c.Clamd)
+		if err := clam.Ping(); err != nil {
+			return nil, fmt.Errorf("clamd: %v", err)
+		}
+		pm.Scanner = clam
+	}
+
+	var sessions *mrs.Sessions
+	if keys := splitKeys(c.SessionKeys); len(keys) > 0 {
+		sessions = mrs.NewSessions(keys...)
+		sessions.Secure = c.SecureCookies
+		h.Auth = sessions
+	}
+	if keys := splitKeys(c.SigningKeys); len(keys) > 0 {
+		h.Signer = mrs.NewURLSigner(keys...)
+	}
+
+	if sessions == nil {
+		log.Print("no session keys, the moderation routes are disabled")
+	}
+	var handler http.Handler = routes(h, c.IDs, sessions != nil)
+	if c.CSRF {
+		csrf := mrs.NewCSRF(sessions)
+		csrf.Secure = c.SecureCookies
+		csrf.MaxFormSize = c.MaxUploadSize
+		handler = csrf.Protect(handler)
+	}
+	return handler, nil
+}
+
+func splitKeys(s string) [][]byte {
+	var keys [][]byte
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, []byte(k))
+		}
+	}
+	return keys
+}
+
+// serve runs the server until it is interrupted, then shuts it down gracefully.
+func serve(c *config, h http.Handler) error {
+	srv := &http.Server{Addr: c.Listen, Handler: h}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", c.Listen)
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	log.Print("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown: %v", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/gernest/mrs"
+	"github.com/gorilla/mux"
+)
+
+// The patterns of the profile ids in the routes, by format. They keep the ids of
+// profiles apart from the fixed segments like /profile/picture, the handlers still
+// validate the ids themselves.
+var idPatterns = map[string]string{
+	"uuidv4": `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`,
+	"uuidv7": `[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`,
+	"ulid":   `[0-7][0-9A-HJKMNP-TV-Z]{25}`,
+}
+
+// routes registers the handlers, ids is one of the keys of idPatterns. The moderation
+// routes are only registered when moderation is true, since they need an
+// Authenticator to tell who the moderators are.
+func routes(h *mrs.Handlers, ids string, moderation bool) *mux.Router {
+	id := "{id:" + idPatterns[ids] + "}"
+	r := mux.NewRouter()
+	r.HandleFunc("/profile", h.Create)
+	r.HandleFunc("/profile/"+id, h.Home)
+	r.HandleFunc("/profile/update/"+id, h.Update)
+	r.HandleFunc("/profile/picture/"+id, h.ProfilePic)
+	r.HandleFunc("/profile/crop/"+id, h.Crop)
+	r.HandleFunc("/profile/uploads/"+id, h.FileUploads)
+	r.HandleFunc("/profile/usage/"+id, h.Usage)
+	r.HandleFunc("/profile/"+id+"/report", h.Report)
+	r.HandleFunc("/photo/{id}", h.Photo)
+	if !moderation {
+		return r
+	}
+	r.HandleFunc("/moderation", h.ModerationQueue)
+	r.HandleFunc("/moderation/{id}/approve", h.ApprovePhoto)
+	r.HandleFunc("/moderation/{id}/reject", h.RejectPhoto)
+	r.HandleFunc("/photo/{id}/similar", h.SimilarPhotos)
+	r.HandleFunc("/reports", h.Reports)
+	return r
+}
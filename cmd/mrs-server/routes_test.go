@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gernest/mrs"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// route returns the path template of the route matching path, with the id patterns
+// written {id}, and the id. The template is empty when no route matches.
+func route(r *mux.Router, path string) (string, string) {
+	req, _ := http.NewRequest("GET", path, nil)
+	var m mux.RouteMatch
+	if !r.Match(req, &m) || m.Route == nil {
+		return "", ""
+	}
+	tmpl, _ := m.Route.GetPathTemplate()
+	for _, p := range idPatterns {
+		tmpl = strings.Replace(tmpl, "{id:"+p+"}", "{id}", -1)
+	}
+	return tmpl, m.Vars["id"]
+}
+
+func TestRoutes(t *testing.T) {
+	h := mrs.NewHandlers("imgs.db", "meta", "data", &render.Options{Directory: "templates"})
+	ids := map[string]string{
+		"uuidv4": "1b4e28ba-2fa1-41d2-883f-0016d3cca427",
+		"uuidv7": "017f22e2-79b0-7cc3-98c4-dc0c0c07398f",
+		"ulid":   "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+	}
+	for format, id := range ids {
+		r := routes(h, format, false)
+		sample := []struct {
+			path, tmpl string
+		}{
+			{"/profile/" + id, "/profile/{id}"},
+			{"/profile/update/" + id, "/profile/update/{id}"},
+			{"/profile/picture/" + id, "/profile/picture/{id}"},
+			{"/profile/crop/" + id, "/profile/crop/{id}"},
+			{"/profile/uploads/" + id, "/profile/uploads/{id}"},
+			{"/profile/usage/" + id, "/profile/usage/{id}"},
+			{"/profile/" + id + "/report", "/profile/{id}/report"},
+		}
+		for _, v := range sample {
+			tmpl, actual := route(r, v.path)
+			if tmpl != v.tmpl || actual != id {
+				t.Errorf("%s %s: expected %s with id %s got %q with id %q", format, v.path, v.tmpl, id, tmpl, actual)
+			}
+		}
+		// the fixed segments and ids of other formats are not taken for ids.
+		for _, path := range []string{"/profile/picture", "/profile/usage", "/profile/not-an-id"} {
+			if tmpl, _ := route(r, path); tmpl != "" {
+				t.Errorf("%s %s: expected no route got %s", format, path, tmpl)
+			}
+		}
+		for other, otherID := range ids {
+			if tmpl, _ := route(r, "/profile/"+otherID); other != format && tmpl != "" {
+				t.Errorf("%s: expected no route for a %s id got %s", format, other, tmpl)
+			}
+		}
+	}
+
+	for _, moderation := range []bool{false, true} {
+		r := routes(h, "uuidv4", moderation)
+		if tmpl, _ := route(r, "/profile"); tmpl != "/profile" {
+			t.Errorf("expected /profile got %q", tmpl)
+		}
+		if tmpl, id := route(r, "/photo/abc"); tmpl != "/photo/{id}" || id != "abc" {
+			t.Errorf("expected /photo/{id} with id abc got %q with id %q", tmpl, id)
+		}
+		for _, path := range []string{"/moderation", "/moderation/abc/approve", "/moderation/abc/reject", "/photo/abc/similar", "/reports"} {
+			if tmpl, _ := route(r, path); (tmpl != "") != moderation {
+				t.Errorf("%s: expected it to be routed %v got %q", path, moderation, tmpl)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gernest/mrs"
+)
+
+// check prints the problems found in the photo database and the profiles.
+func (t *tool) check() error {
+	problems, err := t.pm.Check()
+	if err != nil {
+		return err
+	}
+	ids, err := mrs.ProfileIDs(t.format)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		p, err := mrs.NewProfile(id).Get()
+		if errors.Is(err, mrs.ErrProfileNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		found, err := t.pm.CheckProfile(p)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, found...)
+	}
+	for _, v := range problems {
+		fmt.Println(v)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Printf("%d profiles checked, no problems found\n", len(ids))
+	return nil
+}
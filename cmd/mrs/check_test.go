@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tl := newTool(t)
+	p := newProfile(t)
+	photo := savePhoto(t, tl.pm, p.ID)
+	p.Picture = photo.ID
+	if err := p.UpdateIf(p.Version); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.run([]string{"check"}); err != nil {
+		t.Fatalf("expected no problems got %v", err)
+	}
+
+	// the photo is gone but the profile still references it.
+	if _, err := tl.pm.Delete(photo.ID); err != nil {
+		t.Fatal(err)
+	}
+	err := tl.run([]string{"check"})
+	if err == nil || err.Error() != "1 problems found" {
+		t.Errorf("expected 1 problems found got %v", err)
+	}
+	if code := exitCode(err); code != 1 {
+		t.Errorf("expected exit code 1 got %d", code)
+	}
+}
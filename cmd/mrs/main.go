@@ -0,0 +1,158 @@
+// Command mrs is the administration tool for the databases of the mrs package. It
+// works directly on the db directory of the profiles and on the photo database, so
+// it is best run while the server is stopped.
+//
+// Usage:
+//
+//	mrs [flags] profiles list
+//	mrs [flags] profiles show <id>
+//	mrs [flags] profiles create [<id>]
+//	mrs [flags] profiles edit <id>
+//	mrs [flags] profiles delete [-photos] <id>
+//	mrs [flags] photos list [<profile id>]
+//	mrs [flags] photos export <id> [<file>]
+//	mrs [flags] photos delete <id>
+//	mrs [flags] photos backfill
+//	mrs [flags] check
+//
+// The profiles edit command opens the profile as json in $EDITOR, the profile is saved
+// when the editor exits, unless someone else modified it in the meantime.
+//
+// The photos delete command removes the photo along with its renditions, and from the
+// profile which references it.
+//
+// The photos backfill command computes the hash, the perceptual hashes and the
+// placeholder of photos saved before they were recorded, and rebuilds the index used
+// to find duplicates.
+//
+// The check command looks for inconsistencies between the profiles and the photos,
+// it exits with status 1 when it finds any.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gernest/mrs"
+)
+
+// errUsage is returned by the commands when they are given bad arguments.
+var errUsage = errors.New("bad arguments")
+
+const usage = `usage: mrs [flags] <command>
+
+commands:
+  profiles list
+  profiles show <id>
+  profiles create [<id>]
+  profiles edit <id>
+  profiles delete [-photos] <id>
+  photos list [<profile id>]
+  photos export <id> [<file>]
+  photos delete <id>
+  photos backfill
+  check
+
+flags:`
+
+// tool holds what the commands work on.
+type tool struct {
+	pm     *mrs.PhotoManager
+	format mrs.IDScheme
+}
+
+func main() {
+	root := flag.String("root", ".", "the directory holding the db directory of the profiles")
+	db := flag.String("db", "imgs.db", "the photo database, relative to root")
+	meta := flag.String("meta", "meta", "the bucket of the photo metadata")
+	data := flag.String("data", "data", "the bucket of the photo data")
+	ids := flag.String("ids", "uuidv4", "the format of the profile ids, uuidv4, uuidv7 or ulid")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := os.Chdir(*root); err != nil {
+		fmt.Fprintln(os.Stderr, "mrs:", err)
+		os.Exit(1)
+	}
+	t := &tool{pm: mrs.NewPhotoManager(*db, *meta, *data)}
+	switch *ids {
+	case "uuidv4":
+		t.format = mrs.UUIDv4
+	case "uuidv7":
+		t.format = mrs.UUIDv7
+	case "ulid":
+		t.format = mrs.ULID
+	default:
+		fmt.Fprintf(os.Stderr, "mrs: unknown id format %s\n", *ids)
+		os.Exit(2)
+	}
+
+	err := t.run(flag.Args())
+	switch exitCode(err) {
+	case 2:
+		flag.Usage()
+		os.Exit(2)
+	case 1:
+		fmt.Fprintln(os.Stderr, "mrs:", err)
+		os.Exit(1)
+	}
+}
+
+// exitCode returns the exit status of a command which returned err, 2 for bad
+// arguments and 1 for the other errors, including the problems found by check.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	return 1
+}
+
+func (t *tool) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if args[0] == "check" && len(args) == 1 {
+		return t.check()
+	}
+	if len(args) < 2 {
+		return errUsage
+	}
+	cmd, args := args[0]+" "+args[1], args[2:]
+	switch cmd {
+	case "profiles list":
+		return t.listProfiles(args)
+	case "profiles show":
+		return t.showProfile(args)
+	case "profiles create":
+		return t.createProfile(args)
+	case "profiles edit":
+		return t.editProfile(args)
+	case "profiles delete":
+		return t.deleteProfile(args)
+	case "photos list":
+		return t.listPhotos(args)
+	case "photos export":
+		return t.exportPhoto(args)
+	case "photos delete":
+		return t.deletePhoto(args)
+	case "photos backfill":
+		return t.backfill(args)
+	}
+	return errUsage
+}
+
+// profileID parses the single id argument of a command.
+func (t *tool) profileID(args []string) (mrs.ProfileID, error) {
+	if len(args) != 1 {
+		return mrs.ProfileID{}, errUsage
+	}
+	return mrs.ParseProfileIDFormat(args[0], t.format)
+}
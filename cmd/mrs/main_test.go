@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"os"
+	"testing"
+
+	"github.com/gernest/mrs"
+)
+
+// newTool returns a tool working in a temporary directory, which is the working
+// directory until the end of the test.
+func newTool(t *testing.T) *tool {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err = os.Mkdir("db", 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &tool{pm: mrs.NewPhotoManager("imgs.db", "meta", "data"), format: mrs.UUIDv4}
+}
+
+// newProfile creates a profile with a new id.
+func newProfile(t *testing.T) *mrs.Profile {
+	id, err := mrs.NewProfileID(mrs.UUIDv4, mrs.UUIDv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := mrs.NewProfile(id)
+	if err = p.Create(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+type nopFile struct {
+	*bytes.Reader
+}
+
+func (nopFile) Close() error { return nil }
+
+// savePhoto saves a small png photo uploaded by the profile.
+func savePhoto(t *testing.T, pm *mrs.PhotoManager, profileID string) *mrs.Photo {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(1, 1, color.White)
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	var file multipart.File = nopFile{bytes.NewReader(buf.Bytes())}
+	photo, err := pm.SaveSingle(&mrs.FileUpload{Body: &file, Ext: "png"}, profileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return photo
+}
+
+func TestRun_usage(t *testing.T) {
+	tl := newTool(t)
+	sample := [][]string{
+		nil,
+		{"profiles"},
+		{"profiles", "rename"},
+		{"check", "now"},
+		{"profiles", "show"},
+		{"profiles", "delete", "-all", "id"},
+		{"photos", "list", "a", "b"},
+		{"photos", "backfill", "all"},
+	}
+	for _, args := range sample {
+		err := tl.run(args)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("%q: expected %v got %v", args, errUsage, err)
+		}
+		if code := exitCode(err); code != 2 {
+			t.Errorf("%q: expected exit code 2 got %d", args, code)
+		}
+	}
+	if code := exitCode(nil); code != 0 {
+		t.Errorf("expected exit code 0 got %d", code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gernest/mrs"
+)
+
+func (t *tool) listPhotos(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	profileID := ""
+	if len(args) == 1 {
+		id, err := t.profileID(args)
+		if err != nil {
+			return err
+		}
+		profileID = id.String()
+	}
+	photos, err := t.pm.Photos(profileID)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROFILE\tTYPE\tSIZE\tSTATUS\tUPLOADED")
+	for _, photo := range photos {
+		status := photo.Status
+		if photo.Approved() {
+			status = mrs.StatusApproved
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", photo.ID, photo.UploadedBy, photo.Type,
+			photo.Size, status, photo.UploadedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// exportPhoto writes the data of the photo to the file, or to stdout if there is no
+// file or it is -.
+func (t *tool) exportPhoto(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+	var out io.Writer = os.Stdout
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return t.pm.ReadData(args[0], func(data io.ReadSeeker) error {
+		_, err := io.Copy(out, data)
+		return err
+	})
+}
+
+// deletePhoto deletes the photo, and removes it from the profile it belongs to.
+func (t *tool) deletePhoto(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	photo, err := t.pm.Delete(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted photo %s\n", photo.ID)
+	id, err := mrs.ParseProfileIDFormat(photo.UploadedBy, t.format)
+	if err != nil {
+		return nil
+	}
+	p, err := mrs.NewProfile(id).Get()
+	if err != nil {
+		return nil
+	}
+	changed := false
+	if p.Picture == photo.ID {
+		p.Picture = ""
+		changed = true
+	}
+	var photos []string
+	for _, v := range p.Photos {
+		if v == photo.ID {
+			changed = true
+			continue
+		}
+		photos = append(photos, v)
+	}
+	if !changed {
+		return nil
+	}
+	p.Photos = photos
+	if err = p.UpdateIf(p.Version); err != nil {
+		return fmt.Errorf("profile %s: %v", p.ID, err)
+	}
+	fmt.Printf("removed it from profile %s\n", p.ID)
+	return nil
+}
+
+func (t *tool) backfill(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	stats, err := t.pm.BackfillHashes()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d photos, %d hashed, %d fingerprinted, %d placeholders, %d duplicates\n",
+		stats.Photos, stats.Hashed, stats.Fingerprinted, stats.Placeholders, stats.Duplicates)
+	return nil
+}
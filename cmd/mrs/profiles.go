@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gernest/mrs"
+)
+
+func (t *tool) listProfiles(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	ids, err := mrs.ProfileIDs(t.format)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tVERSION\tPICTURE\tPHOTOS\tUPDATED")
+	for _, id := range ids {
+		p, err := mrs.NewProfile(id).Get()
+		if errors.Is(err, mrs.ErrProfileNotFound) {
+			// the database of a deleted profile.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", p.ID, p.Version, p.Picture, len(p.Photos),
+			p.UpdatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func (t *tool) showProfile(args []string) error {
+	id, err := t.profileID(args)
+	if err != nil {
+		return err
+	}
+	p, err := mrs.NewProfile(id).Get()
+	if err != nil {
+		return err
+	}
+	return printJSON(p)
+}
+
+func (t *tool) createProfile(args []string) error {
+	var id mrs.ProfileID
+	var err error
+	if len(args) == 0 {
+		id, err = mrs.NewProfileID(t.format, t.format)
+	} else {
+		id, err = t.profileID(args)
+	}
+	if err != nil {
+		return err
+	}
+	p := mrs.NewProfile(id)
+	if _, err = mrs.NewProfile(id).Get(); err == nil {
+		return fmt.Errorf("profile %s already exists", id)
+	}
+	if err = p.Create(); err != nil {
+		return err
+	}
+	return printJSON(p)
+}
+
+// editProfile opens the profile in $EDITOR as json. Fields which are left out of the
+// json are not changed, and the id, version and timestamps are managed by mrs.
+func (t *tool) editProfile(args []string) error {
+	id, err := t.profileID(args)
+	if err != nil {
+		return err
+	}
+	p, err := mrs.NewProfile(id).Get()
+	if err != nil {
+		return err
+	}
+	version, createdAt := p.Version, p.CreatedAt
+	before, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	after, err := edit(before)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bytes.TrimSpace(before), bytes.TrimSpace(after)) {
+		fmt.Println("no changes")
+		return nil
+	}
+	p.Privacy = nil
+	if err = json.Unmarshal(after, p); err != nil {
+		return fmt.Errorf("bad profile: %v", err)
+	}
+	p.ID, p.CreatedAt = id.String(), createdAt
+	if err = p.Validate(); err != nil {
+		return err
+	}
+	if err = p.UpdateIf(version); err != nil {
+		return err
+	}
+	fmt.Printf("saved version %d\n", p.Version)
+	return nil
+}
+
+// edit opens data in $EDITOR, or vi, and returns the edited data.
+func edit(data []byte) ([]byte, error) {
+	f, err := os.CreateTemp("", "mrs-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %v", err)
+	}
+	return os.ReadFile(f.Name())
+}
+
+func (t *tool) deleteProfile(args []string) error {
+	fs := flag.NewFlagSet("profiles delete", flag.ContinueOnError)
+	photos := fs.Bool("photos", false, "delete the photos of the profile too")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	id, err := t.profileID(fs.Args())
+	if err != nil {
+		return err
+	}
+	p, err := mrs.NewProfile(id).Get()
+	if err != nil {
+		return err
+	}
+	if *photos {
+		list, err := t.pm.Photos(p.ID)
+		if err != nil {
+			return err
+		}
+		for _, photo := range list {
+			if _, err = t.pm.Delete(photo.ID); err != nil {
+				return fmt.Errorf("photo %s: %v", photo.ID, err)
+			}
+		}
+		fmt.Printf("deleted %d photos\n", len(list))
+	}
+	if err = p.Deleta(); err != nil {
+		return err
+	}
+	fmt.Printf("deleted profile %s\n", p.ID)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", data)
+	return err
+}
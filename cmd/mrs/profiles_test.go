@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gernest/mrs"
+)
+
+// setEditor makes $EDITOR a shell script running script on the file to edit, which
+// is $1.
+func setEditor(t *testing.T, script string) {
+	path := filepath.Join(t.TempDir(), "editor")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", path)
+}
+
+func TestEditProfile(t *testing.T) {
+	tl := newTool(t)
+	p := newProfile(t)
+	args := []string{"profiles", "edit", p.ID}
+
+	setEditor(t, `sed -e 's/"city": ""/"city": "Arusha"/' -e 's/"version": [0-9]*/"version": 40/' "$1" > "$1.new" && mv "$1.new" "$1"`)
+	if err := tl.run(args); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mrs.NewProfile(mrs.MustParseProfileID(p.ID)).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.City != "Arusha" {
+		t.Errorf("expected Arusha got %s", got.City)
+	}
+	// the version is managed by mrs, editing it has no effect.
+	if got.Version != p.Version+1 {
+		t.Errorf("expected version %d got %d", p.Version+1, got.Version)
+	}
+	if !got.CreatedAt.Equal(p.CreatedAt) {
+		t.Errorf("expected created at %v got %v", p.CreatedAt, got.CreatedAt)
+	}
+
+	// leaving the editor without changes saves nothing.
+	setEditor(t, "true")
+	if err = tl.run(args); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := mrs.NewProfile(mrs.MustParseProfileID(p.ID)).Get(); again.Version != got.Version {
+		t.Errorf("expected version %d got %d", got.Version, again.Version)
+	}
+
+	setEditor(t, `echo '{"city": ' > "$1"`)
+	if err = tl.run(args); err == nil || !strings.Contains(err.Error(), "bad profile") {
+		t.Errorf("expected a bad profile error got %v", err)
+	}
+	setEditor(t, "exit 3")
+	if err = tl.run(args); err == nil || !strings.Contains(err.Error(), "editor") {
+		t.Errorf("expected an editor error got %v", err)
+	}
+	if code := exitCode(err); code != 1 {
+		t.Errorf("expected exit code 1 got %d", code)
+	}
+}
+
+func TestDeleteProfile(t *testing.T) {
+	tl := newTool(t)
+	kept, deleted := newProfile(t), newProfile(t)
+	savePhoto(t, tl.pm, kept.ID)
+	savePhoto(t, tl.pm, deleted.ID)
+
+	if err := tl.run([]string{"profiles", "delete", kept.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.run([]string{"profiles", "delete", "-photos", deleted.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*mrs.Profile{kept, deleted} {
+		_, err := mrs.NewProfile(mrs.MustParseProfileID(p.ID)).Get()
+		if !errors.Is(err, mrs.ErrProfileNotFound) {
+			t.Errorf("%s: expected %v got %v", p.ID, mrs.ErrProfileNotFound, err)
+		}
+	}
+	if photos, err := tl.pm.Photos(kept.ID); err != nil || len(photos) != 1 {
+		t.Errorf("expected the photo to be kept without -photos, got %d photos and %v", len(photos), err)
+	}
+	if photos, err := tl.pm.Photos(deleted.ID); err != nil || len(photos) != 0 {
+		t.Errorf("expected the photo to be deleted with -photos, got %d photos and %v", len(photos), err)
+	}
+
+	err := tl.run([]string{"profiles", "delete", deleted.ID})
+	if !errors.Is(err, mrs.ErrProfileNotFound) {
+		t.Errorf("expected %v got %v", mrs.ErrProfileNotFound, err)
+	}
+}
@@ -0,0 +1,284 @@
+package mrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// defaultAvatarSizes are the sizes of the square renditions of profile pictures.
+var defaultAvatarSizes = []int{64, 128, 256}
+
+var (
+	// ErrInvalidCrop is the message when the crop parameters are malformed or fall
+	// outside of the photo.
+	ErrInvalidCrop = errors.New("sorry: invalid crop parameters")
+
+	// ErrInvalidRevision is the message when the crop revision in a photo url is
+	// malformed.
+	ErrInvalidRevision = errors.New("sorry: invalid crop revision")
+)
+
+// Crop is how a photo is cropped into square avatars. It is either a rectangle, in
+// pixels of the photo, or a focal point given as fractions of the width and height
+// of the photo, which the largest square possible is centered on. The rectangle wins
+// when both are set, and without any the square is centered on the photo.
+//
+// The Revision is incremented every time the photo is cropped, it is part of the urls
+// of the square renditions so that the content behind a url never changes.
+type Crop struct {
+	X        int     `json:"x,omitempty"`
+	Y        int     `json:"y,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	FocusX   float64 `json:"focus_x,omitempty"`
+	FocusY   float64 `json:"focus_y,omitempty"`
+	Revision int     `json:"revision"`
+}
+
+// ParseCrop parses the crop parameters of a request, crop is a rectangle given as
+// x,y,width,height and focus a focal point given as x,y. Both may be empty, in which
+// case the returned crop centers the square on the photo.
+func ParseCrop(crop, focus string) (*Crop, error) {
+	c := &Crop{FocusX: 0.5, FocusY: 0.5}
+	if crop != "" {
+		v, err := parseNumbers(crop, 4)
+		if err != nil {
+			return nil, err
+		}
+		c.X, c.Y, c.Width, c.Height = int(v[0]), int(v[1]), int(v[2]), int(v[3])
+	}
+	if focus != "" {
+		v, err := parseNumbers(focus, 2)
+		if err != nil {
+			return nil, err
+		}
+		c.FocusX, c.FocusY = v[0], v[1]
+	}
+	return c, c.validate()
+}
+
+func parseNumbers(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, ErrInvalidCrop
+	}
+	v := make([]float64, n)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, ErrInvalidCrop
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+func (c *Crop) validate() error {
+	if c.Width < 0 || c.Height < 0 || c.X < 0 || c.Y < 0 ||
+		c.FocusX < 0 || c.FocusX > 1 || c.FocusY < 0 || c.FocusY > 1 {
+		return ErrInvalidCrop
+	}
+	if (c.Width == 0) != (c.Height == 0) {
+		return ErrInvalidCrop
+	}
+	return nil
+}
+
+// Square returns the square of an image with the given bounds, according to c.
+func (c *Crop) Square(b image.Rectangle) (image.Rectangle, error) {
+	if c.Width > 0 {
+		r := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height).Add(b.Min).Intersect(b)
+		if r.Empty() {
+			return image.Rectangle{}, ErrInvalidCrop
+		}
+		side := min2(r.Dx(), r.Dy())
+		x := r.Min.X + (r.Dx()-side)/2
+		y := r.Min.Y + (r.Dy()-side)/2
+		return image.Rect(x, y, x+side, y+side), nil
+	}
+	side := min2(b.Dx(), b.Dy())
+	x := b.Min.X + int(c.FocusX*float64(b.Dx())) - side/2
+	y := b.Min.Y + int(c.FocusY*float64(b.Dy())) - side/2
+	x = clamp(x, b.Min.X, b.Max.X-side)
+	y = clamp(y, b.Min.Y, b.Max.Y-side)
+	return image.Rect(x, y, x+side, y+side), nil
+}
+
+func min2(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// SquareRendition returns the name of the square rendition of the given size.
+func SquareRendition(size int) string {
+	return fmt.Sprintf("square-%d", size)
+}
+
+// renditionKey is the key of the data of a rendition in the data bucket, next to the
+// data of the original which is keyed by the photo id.
+func renditionKey(id, rendition string, revision int) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%d", id, rendition, revision))
+}
+
+// ReadRendition is like ReadData, but for a rendition of the photo at the given crop
+// revision. The error is ErrPhotoNotFound if there is no such rendition.
+func (p *PhotoManager) ReadRendition(photo *Photo, rendition string, revision int, fn func(io.ReadSeeker) error) error {
+	if rendition == RenditionOriginal {
+		return p.ReadData(photo.ID, fn)
+	}
+	if photo.Crop == nil || photo.Crop.Revision != revision {
+		return ErrPhotoNotFound
+	}
+	return p.get(p.DataBucket, string(renditionKey(photo.ID, rendition, revision)), func(data []byte) error {
+		return fn(bytes.NewReader(data))
+	})
+}
+
+// Crop crops the photo with the given id and generates its square renditions, one for
+// every size in AvatarSizes. The renditions of the previous crop are removed. The
+// renditions count toward the usage of the profile, the error is ErrQuotaExceeded when
+// they would take it past its Quota.
+func (p *PhotoManager) Crop(id string, c *Crop) (*Photo, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	photo, err := p.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.GetData(id)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodePhoto(photo, data)
+	if err != nil {
+		return nil, err
+	}
+	square, err := c.Square(img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	renditions := make(map[string][]byte)
+	for _, size := range p.AvatarSizes {
+		name := SquareRendition(size)
+		b, err := p.Encoding.encode(resize(img, square, size), photo.format(), name)
+		if err != nil {
+			return nil, err
+		}
+		renditions[name] = b
+	}
+
+	err = update(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		content := tx.Bucket([]byte(p.DataBucket))
+		if meta == nil || content == nil {
+			return ErrPhotoNotFound
+		}
+		m := meta.Get([]byte(id))
+		if m == nil {
+			return ErrPhotoNotFound
+		}
+		current := new(Photo)
+		if err := json.Unmarshal(m, current); err != nil {
+			return err
+		}
+		crop := *c
+		crop.Revision = 1
+		if current.Crop != nil {
+			crop.Revision = current.Crop.Revision + 1
+		}
+		var size int64
+		for _, b := range renditions {
+			size += int64(len(b))
+		}
+		u := &Usage{ProfileID: current.UploadedBy}
+		if err := p.readUsage(tx, u); err != nil {
+			return err
+		}
+		if grow := size - current.RenditionsSize; grow > 0 {
+			if err := p.Quota.check(u, 0, grow); err != nil {
+				return err
+			}
+		}
+		if u.Bytes += size - current.RenditionsSize; u.Bytes < 0 {
+			u.Bytes = 0
+		}
+		if err := p.writeUsage(tx, u); err != nil {
+			return err
+		}
+		if err := deleteRenditions(content, id); err != nil {
+			return err
+		}
+		for name, b := range renditions {
+			if err := content.Put(renditionKey(id, name, crop.Revision), b); err != nil {
+				return err
+			}
+		}
+		current.RenditionsSize = size
+		current.Crop = &crop
+		current.UpdatedAt = time.Now()
+		m, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		photo = current
+		return meta.Put([]byte(id), m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// deleteRenditions removes all the renditions of the photo with the given id, whatever
+// their crop revision. They are keyed by the id followed by a slash.
+func deleteRenditions(content *bolt.Bucket, id string) error {
+	var keys [][]byte
+	prefix := []byte(id + "/")
+	c := content.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := content.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resize returns the part r of img scaled to a size x size image.
+func resize(img image.Image, r image.Rectangle, size int) image.Image {
+	return shrink(subImage{img, r}, size, size)
+}
+
+// subImage is the part of an image within r.
+type subImage struct {
+	image.Image
+	r image.Rectangle
+}
+
+func (s subImage) Bounds() image.Rectangle {
+	return s.r
+}
@@ -0,0 +1,295 @@
+package mrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestParseCrop(t *testing.T) {
+	sample := []struct {
+		crop, focus string
+		expect      *Crop
+	}{
+		{"", "", &Crop{FocusX: 0.5, FocusY: 0.5}},
+		{"10,20,100,50", "", &Crop{X: 10, Y: 20, Width: 100, Height: 50, FocusX: 0.5, FocusY: 0.5}},
+		{"", "0.2, 0.8", &Crop{FocusX: 0.2, FocusY: 0.8}},
+		{"1,2,3", "", nil},
+		{"a,b,c,d", "", nil},
+		{"0,0,10,0", "", nil},
+		{"-1,0,10,10", "", nil},
+		{"", "1.5,0.5", nil},
+		{"", "0.5", nil},
+	}
+	for _, v := range sample {
+		c, err := ParseCrop(v.crop, v.focus)
+		if v.expect == nil {
+			if !errors.Is(err, ErrInvalidCrop) {
+				t.Errorf("%q %q: expected %v got %v", v.crop, v.focus, ErrInvalidCrop, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q %q: %v", v.crop, v.focus, err)
+			continue
+		}
+		if *c != *v.expect {
+			t.Errorf("%q %q: expected %+v got %+v", v.crop, v.focus, v.expect, c)
+		}
+	}
+}
+
+func TestCrop_Square(t *testing.T) {
+	b := image.Rect(0, 0, 200, 100)
+	sample := []struct {
+		crop   Crop
+		expect image.Rectangle
+	}{
+		{Crop{FocusX: 0.5, FocusY: 0.5}, image.Rect(50, 0, 150, 100)},
+		{Crop{FocusX: 0, FocusY: 0.5}, image.Rect(0, 0, 100, 100)},
+		{Crop{FocusX: 0.9, FocusY: 0.1}, image.Rect(100, 0, 200, 100)},
+		{Crop{X: 10, Y: 10, Width: 40, Height: 60}, image.Rect(10, 20, 50, 60)},
+		{Crop{X: 180, Y: 0, Width: 50, Height: 50}, image.Rect(180, 15, 200, 35)},
+	}
+	for _, v := range sample {
+		r, err := v.crop.Square(b)
+		if err != nil {
+			t.Errorf("%+v: %v", v.crop, err)
+			continue
+		}
+		if r != v.expect {
+			t.Errorf("%+v: expected %v got %v", v.crop, v.expect, r)
+		}
+	}
+	c := Crop{X: 300, Y: 0, Width: 10, Height: 10}
+	if _, err := c.Square(b); !errors.Is(err, ErrInvalidCrop) {
+		t.Errorf("expected %v got %v", ErrInvalidCrop, err)
+	}
+}
+
+func TestPhotoManager_Crop(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := pm.Crop(photo.ID, &Crop{FocusX: 0.5, FocusY: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Crop == nil || first.Crop.Revision != 1 {
+		t.Fatalf("expected the first revision got %+v", first.Crop)
+	}
+	second, err := pm.Crop(photo.ID, &Crop{X: 0, Y: 0, Width: 50, Height: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Crop.Revision != 2 {
+		t.Errorf("expected revision 2 got %d", second.Crop.Revision)
+	}
+	rendition := SquareRendition(128)
+	err = pm.ReadRendition(second, rendition, 1, func(io.ReadSeeker) error { return nil })
+	if !errors.Is(err, ErrPhotoNotFound) {
+		t.Errorf("expected the old rendition to be removed got %v", err)
+	}
+	err = pm.ReadRendition(second, rendition, 2, func(data io.ReadSeeker) error {
+		cfg, err := jpeg.DecodeConfig(data)
+		if err != nil {
+			return err
+		}
+		if cfg.Width != 128 || cfg.Height != 128 {
+			t.Errorf("expected 128x128 got %dx%d", cfg.Width, cfg.Height)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := pm.Crop("nope", &Crop{}); !errors.Is(err, ErrPhotoNotFound) {
+		t.Errorf("expected %v got %v", ErrPhotoNotFound, err)
+	}
+}
+
+func TestPhotoManager_CropUsage(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := func() int64 {
+		u, err := pm.Usage(pids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Bytes
+	}
+	cropped, err := pm.Crop(photo.ID, &Crop{FocusX: 0.5, FocusY: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cropped.RenditionsSize == 0 {
+		t.Fatal("expected the size of the renditions to be recorded")
+	}
+	if u := usage(); u != int64(photo.Size)+cropped.RenditionsSize {
+		t.Errorf("expected %d bytes got %d", int64(photo.Size)+cropped.RenditionsSize, u)
+	}
+
+	// the renditions of sizes which are no longer generated are removed too.
+	pm.AvatarSizes = []int{32}
+	cropped, err = pm.Crop(photo.ID, &Crop{FocusX: 0.5, FocusY: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != int64(photo.Size)+cropped.RenditionsSize {
+		t.Errorf("expected %d bytes got %d", int64(photo.Size)+cropped.RenditionsSize, u)
+	}
+	problems, err := pm.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems got %v", problems)
+	}
+
+	pm.AvatarSizes = defaultAvatarSizes
+	pm.Quota = Quota{MaxBytes: usage()}
+	_, err = pm.Crop(photo.ID, &Crop{FocusX: 0.5, FocusY: 0.5})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %v got %v", ErrQuotaExceeded, err)
+	}
+	if u := usage(); u != int64(photo.Size)+cropped.RenditionsSize {
+		t.Errorf("expected the usage to stay at %d got %d", int64(photo.Size)+cropped.RenditionsSize, u)
+	}
+
+	if _, err = pm.Delete(photo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != 0 {
+		t.Errorf("expected no usage got %d", u)
+	}
+}
+
+func TestHandlers_Crop(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/crop/{id}", handle.Crop)
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	defer cleanUp()
+	pic, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.Picture = pic.ID
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crop := func(user, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", fmt.Sprintf("/profile/crop/%s", pids[0]), strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := crop(pids[1], `{"focus":"0.5,0.2"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	if w := crop(pids[0], `{"focus":"2,0"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d actual %d", http.StatusBadRequest, w.Code)
+	}
+	if w := crop(pids[0], `{"photo":"nope"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d actual %d", http.StatusNotFound, w.Code)
+	}
+	for i := 1; i <= 2; i++ {
+		w := crop(pids[0], `{"focus":"0.5,0.2"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d actual %d %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		photo := new(Photo)
+		err = json.Unmarshal(w.Body.Bytes(), photo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if photo.Crop == nil || photo.Crop.Revision != i {
+			t.Fatalf("expected revision %d got %+v", i, photo.Crop)
+		}
+	}
+
+	base := fmt.Sprintf("/photo/%s?rendition=%s", pic.ID, SquareRendition(64))
+	sample := []struct {
+		path, cache string
+		code        int
+	}{
+		{base + "&rev=2", DefaultCachePolicy.Rendition, http.StatusOK},
+		{base, "no-cache", http.StatusOK},
+		{base + "&rev=1", "", http.StatusNotFound},
+		{fmt.Sprintf("/photo/%s?rendition=square-7&rev=2", pic.ID), "", http.StatusNotFound},
+		{base + "&rev=two", "", http.StatusBadRequest},
+		{base + "&rev=0", "", http.StatusBadRequest},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", v.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: expected %d actual %d", v.path, v.code, w.Code)
+			continue
+		}
+		if v.code != http.StatusOK {
+			continue
+		}
+		if c := w.Header().Get("Cache-Control"); c != v.cache {
+			t.Errorf("%s: expected Cache-Control %q got %q", v.path, v.cache, c)
+		}
+		cfg, err := jpeg.DecodeConfig(w.Body)
+		if err != nil {
+			t.Errorf("%s: %v", v.path, err)
+			continue
+		}
+		if cfg.Width != 64 || cfg.Height != 64 {
+			t.Errorf("%s: expected 64x64 got %dx%d", v.path, cfg.Width, cfg.Height)
+		}
+	}
+
+	// the etag of a rendition comes from the hash of the photo, not from its data.
+	r, _ := http.NewRequest("GET", base+"&rev=2", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag == "\""+pic.Hash[:32]+"\"" {
+		t.Fatalf("expected the etag of the rendition got %q", etag)
+	}
+	r, _ = http.NewRequest("GET", base+"&rev=2", nil)
+	r.Header.Set("If-None-Match", etag)
+	r.Header.Set("Range", "bytes=0-9")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected %d actual %d", http.StatusNotModified, w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package mrs
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultCSRFCookie = "mrs_csrf"
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFField  = "csrf_token"
+)
+
+var (
+	// ErrCSRF is the message when an unsafe request lacks a valid CSRF token.
+	ErrCSRF = errors.New("sorry: invalid or missing CSRF token")
+)
+
+// CSRF is a middleware protecting unsafe requests, like the multipart uploads of
+// ProfilePic and FileUploads, against cross site request forgery.
+//
+// With Sessions set, it uses the synchronizer token pattern, the token is the one
+// issued with the session. Otherwise it falls back to the double submit pattern, where
+// a random token is set in a cookie which the page has to send back.
+//
+// The token is expected in the X-CSRF-Token header, or for html forms in the
+// csrf_token field. The current token is available to handlers with CSRFToken, Home
+// passes it to the profile_home template as csrf_token.
+type CSRF struct {
+	Sessions *Sessions
+
+	// CookieName is the name of the double submit cookie.
+	CookieName string
+
+	// HeaderName and FieldName are where the token is looked for.
+	HeaderName string
+	FieldName  string
+
+	// Secure is set on the double submit cookie.
+	Secure bool
+
+	// MaxFormSize limits the body of form requests which are parsed to find the token,
+	// larger requests are rejected with 413 Request Entity Too Large. It should match
+	// the MaxUploadSize of the handlers, since the uploads are parsed here first when
+	// the token is not in the header.
+	MaxFormSize int64
+
+	// Exempt reports whether a request doesn't need a token. The default exempts
+	// requests carrying an Authorization: Bearer header, since browsers never attach
+	// those on their own. Only keep it that way if the Authenticator of the handlers
+	// really checks bearer tokens.
+	Exempt func(r *http.Request) bool
+}
+
+// NewCSRF returns a CSRF middleware, sessions may be nil in which case the double
+// submit pattern is used.
+func NewCSRF(sessions *Sessions) *CSRF {
+	return &CSRF{
+		Sessions:    sessions,
+		CookieName:  defaultCSRFCookie,
+		HeaderName:  defaultCSRFHeader,
+		FieldName:   defaultCSRFField,
+		Secure:      true,
+		MaxFormSize: defaultMaxUploadSize,
+		Exempt:      bearerAuth,
+	}
+}
+
+type csrfKey struct{}
+
+// CSRFToken returns the CSRF token of the request, as found by the CSRF middleware.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// Protect wraps next, rejecting unsafe requests without a valid token with 403
+// Forbidden.
+func (c *CSRF) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := c.token(w, r)
+		if err != nil {
+			writeError(w, r, ErrInternal)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, token))
+		if !safeMethod(r.Method) && (c.Exempt == nil || !c.Exempt(r)) {
+			got, err := c.submitted(w, r)
+			if err != nil {
+				writeError(w, r, httpError(err, ErrInternal))
+				return
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeError(w, r, newError(http.StatusForbidden, CodeCSRF, ErrCSRF))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// token returns the expected token of the request, for the double submit pattern a
+// new cookie is issued when there is none.
+func (c *CSRF) token(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c.Sessions != nil {
+		sess, err := c.Sessions.Load(r)
+		if err != nil || sess == nil {
+			return "", nil
+		}
+		return sess.CSRFToken, nil
+	}
+	if ck, err := r.Cookie(c.CookieName); err == nil && ck.Value != "" {
+		return ck.Value, nil
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   c.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// submitted returns the token sent with the request. The header is looked at first,
+// the form is only parsed when the header is missing, and its body is bounded by
+// MaxFormSize. Forms which are too large are reported with a *http.MaxBytesError.
+func (c *CSRF) submitted(w http.ResponseWriter, r *http.Request) (string, error) {
+	if v := r.Header.Get(c.HeaderName); v != "" {
+		return v, nil
+	}
+	ct := r.Header.Get("Content-Type")
+	multipart := strings.HasPrefix(ct, "multipart/form-data")
+	if !multipart && !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+		return "", nil
+	}
+	if c.MaxFormSize > 0 {
+		if r.ContentLength > c.MaxFormSize {
+			return "", &http.MaxBytesError{Limit: c.MaxFormSize}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, c.MaxFormSize)
+	}
+	var err error
+	if multipart {
+		err = r.ParseMultipartForm(defaultMaxMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", err
+	}
+	if err != nil {
+		return "", nil
+	}
+	return r.PostForm.Get(c.FieldName), nil
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func bearerAuth(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -0,0 +1,153 @@
+package mrs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestCSRF_DoubleSubmit(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+	csrf := NewCSRF(nil)
+
+	h := mux.NewRouter()
+	h.Handle("/profile/{id}", csrf.Protect(http.HandlerFunc(handle.Home)))
+	h.Handle("/profile/picture/{id}", csrf.Protect(http.HandlerFunc(handle.ProfilePic)))
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the home page issues the token and exposes it to the template
+	r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", pids[0]), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultCSRFCookie {
+		t.Fatalf("Expected the csrf cookie got %v", cookies)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(w.Body.String(), token) {
+		t.Errorf("Expected %s to contain the token %s", w.Body.String(), token)
+	}
+
+	upload := func(header string, form bool, bearer bool) int {
+		var req *http.Request
+		if form {
+			req = ajaxtWithFields(fmt.Sprintf("/profile/picture/%s", pids[0]), map[string]string{defaultCSRFField: token}, t)
+		} else {
+			req = ajaxtWithFile(fmt.Sprintf("/profile/picture/%s", pids[0]), "profile", t)
+		}
+		req.AddCookie(cookies[0])
+		req.Header.Set("If-Match", "*")
+		if header != "" {
+			req.Header.Set(defaultCSRFHeader, header)
+		}
+		if bearer {
+			req.Header.Set("Authorization", "Bearer api-token")
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	if code := upload("", false, false); code != http.StatusForbidden {
+		t.Errorf("Expected %d actual %d", http.StatusForbidden, code)
+	}
+	if code := upload("forged", false, false); code != http.StatusForbidden {
+		t.Errorf("Expected %d actual %d", http.StatusForbidden, code)
+	}
+	if code := upload(token, false, false); code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, code)
+	}
+	if code := upload("", true, false); code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, code)
+	}
+	if code := upload("", false, true); code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, code)
+	}
+}
+
+func TestCSRF_Sessions(t *testing.T) {
+	sessions := NewSessions(oldKey)
+	csrf := NewCSRF(sessions)
+	ok := csrf.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	}))
+
+	sess := &Session{UserID: pids[0]}
+	login := issue(sessions, sess, t)
+
+	r, _ := http.NewRequest("POST", "/", nil)
+	r.Header.Set("Cookie", login.Header.Get("Cookie"))
+	w := httptest.NewRecorder()
+	ok.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+
+	r.Header.Set(defaultCSRFHeader, sess.CSRFToken)
+	w = httptest.NewRecorder()
+	ok.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	if w.Body.String() != sess.CSRFToken {
+		t.Errorf("Expected %s actual %s", sess.CSRFToken, w.Body.String())
+	}
+
+	// without a session there is nothing to compare with
+	r, _ = http.NewRequest("POST", "/", nil)
+	r.Header.Set(defaultCSRFHeader, sess.CSRFToken)
+	w = httptest.NewRecorder()
+	ok.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestCSRF_MaxFormSize(t *testing.T) {
+	csrf := NewCSRF(nil)
+	csrf.MaxFormSize = 1024
+	h := csrf.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	token := "token"
+	send := func(header string, length int64) *httptest.ResponseRecorder {
+		req := ajaxtWithFields("/profile/picture/"+pids[0], map[string]string{defaultCSRFField: token}, t)
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(&http.Cookie{Name: defaultCSRFCookie, Value: token})
+		if header != "" {
+			req.Header.Set(defaultCSRFHeader, header)
+		}
+		if length != 0 {
+			req.ContentLength = length
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// the form is never parsed when the header has the token.
+	if w := send(token, 0); w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	for _, length := range []int64{0, -1} {
+		w := send("", length)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%d: Expected %d actual %d", length, http.StatusRequestEntityTooLarge, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), CodeTooLarge) {
+			t.Errorf("%d: Expected the %s code got %s", length, CodeTooLarge, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package mrs
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+var (
+	// ErrImageTooLarge is the message when the dimensions of an uploaded image exceed
+	// the DecodeLimits of the PhotoManager.
+	ErrImageTooLarge = errors.New("sorry: the image is too large")
+)
+
+// ImageTooLargeError is the error of images whose dimensions exceed the DecodeLimits,
+// errors.Is matches it with ErrImageTooLarge.
+type ImageTooLargeError struct {
+	Width  int
+	Height int
+	Limits DecodeLimits
+}
+
+func (e *ImageTooLargeError) Error() string {
+	return fmt.Sprintf("%s: %dx%d exceeds %dx%d and %d pixels", ErrImageTooLarge,
+		e.Width, e.Height, e.Limits.MaxWidth, e.Limits.MaxHeight, e.Limits.MaxPixels)
+}
+
+// Is makes errors.Is(err, ErrImageTooLarge) true.
+func (e *ImageTooLargeError) Is(target error) bool {
+	return target == ErrImageTooLarge
+}
+
+// DecodeLimits bound the dimensions of the images which are decoded. A small file can
+// declare a huge image, which would exhaust memory once decoded, so the dimensions are
+// read from the header of the file and checked before the image is decoded.
+type DecodeLimits struct {
+	// MaxWidth and MaxHeight are the maximum width and height in pixels, zero means no
+	// limit.
+	MaxWidth  int
+	MaxHeight int
+
+	// MaxPixels is the maximum width times height, zero means no limit. Decoded
+	// images take about 4 bytes per pixel.
+	MaxPixels int
+}
+
+// DefaultDecodeLimits are the limits of NewPhotoManager, they fit the photos of
+// current cameras and take at most about 200MB once decoded.
+var DefaultDecodeLimits = DecodeLimits{
+	MaxWidth:  16384,
+	MaxHeight: 16384,
+	MaxPixels: 50 * 1000 * 1000,
+}
+
+// check returns an ImageTooLargeError if an image of the given dimensions exceeds
+// the limits.
+func (l *DecodeLimits) check(width, height int) error {
+	if (l.MaxWidth > 0 && width > l.MaxWidth) ||
+		(l.MaxHeight > 0 && height > l.MaxHeight) ||
+		(l.MaxPixels > 0 && int64(width)*int64(height) > int64(l.MaxPixels)) {
+		return &ImageTooLargeError{Width: width, Height: height, Limits: *l}
+	}
+	return nil
+}
+
+// decode decodes the uploaded file, after checking its dimensions against the limits.
+func (l *DecodeLimits) decode(file *FileUpload) (image.Image, error) {
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch file.Ext {
+	case "jpg", "jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "png", "PNG":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	default:
+		return nil, ErrUnsupportedFile
+	}
+	body := *file.Body
+	cfg, err := decodeConfig(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if err = l.check(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	if _, err = body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, err := decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,83 @@
+package mrs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/color"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// bombUpload returns a png upload which declares a width x height image but holds
+// no pixel data.
+func bombUpload(width, height uint32) *FileUpload {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 bit RGBA
+	chunk := append([]byte("IHDR"), ihdr...)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.Write(chunk)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	var file multipart.File = nopFile{bytes.NewReader(buf.Bytes())}
+	return &FileUpload{Body: &file, Ext: formatPNG}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	l := &DecodeLimits{MaxWidth: 100, MaxHeight: 50, MaxPixels: 1000}
+	sample := []struct {
+		w, h int
+		ok   bool
+	}{
+		{10, 10, true},
+		{100, 10, true},
+		{101, 1, false},
+		{1, 51, false},
+		{40, 30, false},
+	}
+	for _, v := range sample {
+		err := l.check(v.w, v.h)
+		if v.ok != (err == nil) {
+			t.Errorf("%dx%d: unexpected %v", v.w, v.h, err)
+		}
+		if err != nil && !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("%dx%d: expected %v got %v", v.w, v.h, ErrImageTooLarge, err)
+		}
+	}
+	if err := (&DecodeLimits{}).check(1<<20, 1<<20); err != nil {
+		t.Errorf("expected no limits got %v", err)
+	}
+}
+
+func TestPhotoManager_DecodeLimits(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	_, err := pm.SaveSingle(bombUpload(50000, 50000), pids[0])
+	var e *ImageTooLargeError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected %v got %v", ErrImageTooLarge, err)
+	}
+	if e.Width != 50000 || e.Height != 50000 {
+		t.Errorf("expected 50000x50000 got %dx%d", e.Width, e.Height)
+	}
+	if he := httpError(err, ErrInternal); he.Status != http.StatusRequestEntityTooLarge || he.Code != CodeImageTooLarge {
+		t.Errorf("expected %d %s got %d %s", http.StatusRequestEntityTooLarge, CodeImageTooLarge, he.Status, he.Code)
+	}
+
+	pm.Limits = DecodeLimits{MaxPixels: 100}
+	if _, err = saveTestPhoto(pm, pids[0], t); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected %v got %v", ErrImageTooLarge, err)
+	}
+	if _, err = pm.SaveSingle(pngUpload(filled(10, 10, color.White), t), pids[0]); err != nil {
+		t.Errorf("expected an image within the limits to be saved got %v", err)
+	}
+}
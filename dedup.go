@@ -0,0 +1,185 @@
+package mrs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+const defaultHashBucket = "hashes"
+
+var (
+	// ErrDuplicatePhoto is returned when a profile uploads a photo it already has and
+	// the PhotoManager is configured to reject duplicates.
+	ErrDuplicatePhoto = errors.New("sorry: the photo has already been uploaded")
+)
+
+// DuplicatePolicy decides what happens when a profile uploads a photo which it already
+// has, that is a photo whose encoded data has the same sha-256 hash.
+type DuplicatePolicy int
+
+const (
+	// DuplicateReuse returns the photo which is already stored instead of saving a
+	// new copy.
+	DuplicateReuse DuplicatePolicy = iota
+
+	// DuplicateReject fails the upload with ErrDuplicatePhoto.
+	DuplicateReject
+
+	// DuplicateAllow saves every upload, even duplicates.
+	DuplicateAllow
+)
+
+// photoHash returns the hex encoded sha-256 hash of the photo data.
+func photoHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashKey is the key of the hash index, the index is per profile so the photos of a
+// profile never resolve to the photos of another.
+func hashKey(profileID, hash string) []byte {
+	return []byte(profileID + "\x00" + hash)
+}
+
+// duplicate looks up the photo of the profile with the same hash as photo, it returns
+// nil if there is none.
+func (p *PhotoManager) duplicate(tx *bolt.Tx, profileID string, photo *Photo) (*Photo, error) {
+	idx := tx.Bucket([]byte(p.HashBucket))
+	meta := tx.Bucket([]byte(p.MetaBucket))
+	if idx == nil || meta == nil {
+		return nil, nil
+	}
+	id := idx.Get(hashKey(profileID, photo.Hash))
+	if id == nil {
+		return nil, nil
+	}
+	data := meta.Get(id)
+	if data == nil {
+		// the photo has been removed, the index entry is stale.
+		return nil, nil
+	}
+	existing := new(Photo)
+	err := json.Unmarshal(data, existing)
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// indexHash adds the photo to the hash index.
+func (p *PhotoManager) indexHash(tx *bolt.Tx, photo *Photo) error {
+	idx, err := tx.CreateBucketIfNotExists([]byte(p.HashBucket))
+	if err != nil {
+		return err
+	}
+	return idx.Put(hashKey(photo.UploadedBy, photo.Hash), []byte(photo.ID))
+}
+
+// BackfillStats reports what BackfillHashes did.
+type BackfillStats struct {
+	// Photos is the number of photos found.
+	Photos int
+
+	// Hashed is the number of photos which had no hash.
+	Hashed int
+
+	// Fingerprinted is the number of photos which had no perceptual hashes.
+	Fingerprinted int
+
+	// Placeholders is the number of photos which had no placeholder.
+	Placeholders int
+
+	// Duplicates is the number of photos which are copies of another photo of the same
+	// profile. They are left in place, but only the first copy is in the index.
+	Duplicates int
+}
+
+// BackfillHashes computes the hash, fingerprint and placeholder of photos saved before
+// they were recorded, and rebuilds the hash index of all the photos.
+func (p *PhotoManager) BackfillHashes() (*BackfillStats, error) {
+	stats := new(BackfillStats)
+	err := update(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if meta == nil {
+			return nil
+		}
+		content := tx.Bucket([]byte(p.DataBucket))
+		var photos []*Photo
+		err := meta.ForEach(func(k, v []byte) error {
+			photo := new(Photo)
+			if err := json.Unmarshal(v, photo); err != nil {
+				return err
+			}
+			photos = append(photos, photo)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if tx.Bucket([]byte(p.HashBucket)) != nil {
+			if err = tx.DeleteBucket([]byte(p.HashBucket)); err != nil {
+				return err
+			}
+		}
+		seen := make(map[string]bool)
+		for _, photo := range photos {
+			stats.Photos++
+			if photo.Hash == "" || photo.Fingerprint == nil || photo.Placeholder == nil {
+				var data []byte
+				if content != nil {
+					data = content.Get([]byte(photo.ID))
+				}
+				if data == nil {
+					return fmt.Errorf("mrs: photo %s has no data", photo.ID)
+				}
+				if photo.Hash == "" {
+					photo.Hash = photoHash(data)
+					stats.Hashed++
+				}
+				if photo.Fingerprint == nil || photo.Placeholder == nil {
+					img, err := decodePhoto(photo, data)
+					if err != nil {
+						return fmt.Errorf("mrs: photo %s: %v", photo.ID, err)
+					}
+					if photo.Fingerprint == nil {
+						photo.Fingerprint = NewFingerprint(img)
+						stats.Fingerprinted++
+					}
+					if photo.Placeholder == nil {
+						photo.Placeholder, err = NewPlaceholder(img)
+						if err != nil {
+							return err
+						}
+						stats.Placeholders++
+					}
+				}
+				m, err := json.Marshal(photo)
+				if err != nil {
+					return err
+				}
+				if err = meta.Put([]byte(photo.ID), m); err != nil {
+					return err
+				}
+			}
+			key := string(hashKey(photo.UploadedBy, photo.Hash))
+			if seen[key] {
+				stats.Duplicates++
+				continue
+			}
+			seen[key] = true
+			if err = p.indexHash(tx, photo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
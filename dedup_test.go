@@ -0,0 +1,136 @@
+package mrs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func saveTestPhoto(pm *PhotoManager, profileID string, t *testing.T) (*Photo, error) {
+	req, err := requestWithFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := pm.GetSingleFileUpload(req, "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm.SaveSingle(up, profileID)
+}
+
+func TestPhotoManager_Duplicates(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	first, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Hash == "" {
+		t.Error("expected the photo hash to be recorded")
+	}
+	again, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("expected the stored photo %s got %s", first.ID, again.ID)
+	}
+	other, err := saveTestPhoto(pm, pids[1], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Error("expected photos of other profiles not to be reused")
+	}
+	u, err := pm.Usage(pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Photos != 1 {
+		t.Errorf("expected 1 photo got %d", u.Photos)
+	}
+
+	req, err := requestMuliFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ups, err := pm.GetUploadFiles(req, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photos, err := pm.SaveMultiple(ups, pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range photos {
+		if v.ID != first.ID {
+			t.Errorf("expected the stored photo %s got %s", first.ID, v.ID)
+		}
+	}
+
+	pm.Duplicates = DuplicateReject
+	_, err = saveTestPhoto(pm, pids[0], t)
+	if !errors.Is(err, ErrDuplicatePhoto) {
+		t.Errorf("expected %v got %v", ErrDuplicatePhoto, err)
+	}
+	if e := httpError(err, ErrInternal); e.Status != 409 {
+		t.Errorf("expected 409 got %d", e.Status)
+	}
+}
+
+func TestPhotoManager_BackfillHashes(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Duplicates = DuplicateAllow
+	defer cleanUp()
+
+	for i := 0; i < 2; i++ {
+		if _, err := saveTestPhoto(pm, pids[0], t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// make it look like the photos were saved before hashes were recorded.
+	err := update(pm.db, func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(pm.HashBucket)); err != nil {
+			return err
+		}
+		meta := tx.Bucket([]byte(pm.MetaBucket))
+		var photos []*Photo
+		meta.ForEach(func(k, v []byte) error {
+			photo := new(Photo)
+			photos = append(photos, photo)
+			return json.Unmarshal(v, photo)
+		})
+		for _, photo := range photos {
+			photo.Hash = ""
+			photo.Fingerprint = nil
+			data, _ := json.Marshal(photo)
+			if err := meta.Put([]byte(photo.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := pm.BackfillHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Photos != 2 || stats.Hashed != 2 || stats.Fingerprinted != 2 || stats.Placeholders != 0 || stats.Duplicates != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	pm.Duplicates = DuplicateReject
+	_, err = saveTestPhoto(pm, pids[0], t)
+	if !errors.Is(err, ErrDuplicatePhoto) {
+		t.Errorf("expected %v got %v", ErrDuplicatePhoto, err)
+	}
+}
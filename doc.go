@@ -1,5 +1,14 @@
 /*
 Package mrs implements handlers for management of user profiles. It uses bolt database
-as its default storage. Note that, this package does not check for sessions.
+as its default storage.
+
+The callers are resolved by the Authenticator set as the Auth of the Handlers, without
+one there is no authentication and anyone can modify any profile. Sessions is an
+Authenticator reading signed, and optionally encrypted, session cookies, so an
+application sharing its keys can log users in for the handlers. Any other session
+scheme can be plugged in with AuthenticatorFunc.
+
+The CSRF middleware protects the unsafe requests. Given Sessions it checks the token
+issued with the session, otherwise it falls back to a double submit cookie.
 */
 package mrs
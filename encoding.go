@@ -0,0 +1,131 @@
+package mrs
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// formatJPEG and formatPNG are the values of the Type of photos.
+	formatJPEG = "jpg"
+	formatPNG  = "png"
+
+	// defaultQuality is the jpeg quality photos have always been stored at.
+	defaultQuality = 98
+
+	// defaultRenditionQuality is the jpeg quality of the renditions, they are small
+	// and seen small, so a lower quality doesn't show.
+	defaultRenditionQuality = 90
+)
+
+// JPEGEncoder writes img to w as jpeg at the given quality, progressive or not.
+type JPEGEncoder func(w io.Writer, img image.Image, quality int, progressive bool) error
+
+// encodeJPEG is the default JPEGEncoder. Baseline jpeg is written by the standard
+// library, progressive jpeg by progressiveJPEG.
+func encodeJPEG(w io.Writer, img image.Image, quality int, progressive bool) error {
+	if progressive {
+		return progressiveJPEG(w, img, quality)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// EncodingPolicy is how uploaded photos and their renditions are encoded before they
+// are stored.
+type EncodingPolicy struct {
+	// Quality is the jpeg quality of the originals, from 1 to 100. The hash used to
+	// find duplicates is the one of the encoded data, so after changing it uploads of
+	// photos stored before are no longer found to be duplicates.
+	Quality int
+
+	// RenditionQuality is the jpeg quality of the renditions, keyed by their name like
+	// square-128. Renditions which are not in the map use a quality of 90.
+	RenditionQuality map[string]int
+
+	// MaxWidth and MaxHeight bound the size of the originals, larger photos are scaled
+	// down keeping their aspect ratio. Zero means no bound.
+	MaxWidth  int
+	MaxHeight int
+
+	// ConvertOpaquePNG stores png photos which have no transparency as jpeg, which is
+	// much smaller for photographs.
+	ConvertOpaquePNG bool
+
+	// Progressive asks for progressive jpeg, which browsers show blurry and refine as
+	// the data arrives. It costs a little more time to encode, and the default
+	// Encoder subsamples the chroma of progressive jpeg.
+	Progressive bool
+
+	// Encoder encodes jpeg, it defaults to the standard library for baseline jpeg and
+	// to a progressive encoder of this package otherwise.
+	Encoder JPEGEncoder
+}
+
+// DefaultEncodingPolicy is the encoding policy of NewPhotoManager. It keeps the format
+// and size of the uploads.
+var DefaultEncodingPolicy = EncodingPolicy{
+	Quality: defaultQuality,
+}
+
+// quality returns the jpeg quality of the given rendition.
+func (e *EncodingPolicy) quality(rendition string) int {
+	if rendition != RenditionOriginal {
+		if q, ok := e.RenditionQuality[rendition]; ok {
+			return q
+		}
+		return defaultRenditionQuality
+	}
+	if e.Quality == 0 {
+		return defaultQuality
+	}
+	return e.Quality
+}
+
+// format returns the format img is stored in when it is uploaded as ext.
+func (e *EncodingPolicy) format(ext string, img image.Image) string {
+	if ext != formatPNG {
+		return formatJPEG
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && e.ConvertOpaquePNG && o.Opaque() {
+		return formatJPEG
+	}
+	return formatPNG
+}
+
+// bound scales img down to fit in MaxWidth x MaxHeight.
+func (e *EncodingPolicy) bound(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if e.MaxWidth > 0 && w > e.MaxWidth {
+		w, h = e.MaxWidth, atLeastOne(h*e.MaxWidth/w)
+	}
+	if e.MaxHeight > 0 && h > e.MaxHeight {
+		w, h = atLeastOne(w*e.MaxHeight/h), e.MaxHeight
+	}
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	return shrink(img, w, h)
+}
+
+// encode encodes a rendition of a photo in the given format.
+func (e *EncodingPolicy) encode(img image.Image, format, rendition string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	if format == formatPNG {
+		err = png.Encode(buf, img)
+	} else {
+		encoder := e.Encoder
+		if encoder == nil {
+			encoder = encodeJPEG
+		}
+		err = encoder(buf, img, e.quality(rendition), e.Progressive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,158 @@
+package mrs
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"os"
+	"testing"
+)
+
+// nopFile is a multipart.File reading from memory.
+type nopFile struct {
+	*bytes.Reader
+}
+
+func (nopFile) Close() error { return nil }
+
+func pngUpload(img image.Image, t *testing.T) *FileUpload {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	var file multipart.File = nopFile{bytes.NewReader(buf.Bytes())}
+	return &FileUpload{Body: &file, Ext: formatPNG}
+}
+
+func filled(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodingPolicy(t *testing.T) {
+	e := &EncodingPolicy{RenditionQuality: map[string]int{"square-64": 70}}
+	if q := e.quality(RenditionOriginal); q != defaultQuality {
+		t.Errorf("expected %d got %d", defaultQuality, q)
+	}
+	if q := e.quality("square-64"); q != 70 {
+		t.Errorf("expected 70 got %d", q)
+	}
+	if q := e.quality("square-128"); q != defaultRenditionQuality {
+		t.Errorf("expected %d got %d", defaultRenditionQuality, q)
+	}
+
+	opaque := filled(10, 10, color.White)
+	clear := filled(10, 10, color.Transparent)
+	if f := e.format(formatPNG, opaque); f != formatPNG {
+		t.Errorf("expected %s got %s", formatPNG, f)
+	}
+	e.ConvertOpaquePNG = true
+	if f := e.format(formatPNG, opaque); f != formatJPEG {
+		t.Errorf("expected %s got %s", formatJPEG, f)
+	}
+	if f := e.format(formatPNG, clear); f != formatPNG {
+		t.Errorf("expected %s got %s", formatPNG, f)
+	}
+
+	sample := []struct {
+		maxW, maxH, w, h int
+	}{
+		{0, 0, 200, 100},
+		{100, 0, 100, 50},
+		{0, 20, 40, 20},
+		{150, 50, 100, 50},
+		{400, 400, 200, 100},
+	}
+	img := filled(200, 100, color.White)
+	for _, v := range sample {
+		e := &EncodingPolicy{MaxWidth: v.maxW, MaxHeight: v.maxH}
+		b := e.bound(img).Bounds()
+		if b.Dx() != v.w || b.Dy() != v.h {
+			t.Errorf("%dx%d: expected %dx%d got %dx%d", v.maxW, v.maxH, v.w, v.h, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestPhotoManager_Encoding(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Duplicates = DuplicateAllow
+	defer cleanUp()
+
+	photo, err := pm.SaveSingle(pngUpload(filled(40, 20, color.White), t), pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Type != formatPNG {
+		t.Errorf("expected %s got %s", formatPNG, photo.Type)
+	}
+
+	var quality int
+	var progressive bool
+	pm.Encoding = EncodingPolicy{
+		Quality:          80,
+		MaxWidth:         20,
+		ConvertOpaquePNG: true,
+		Progressive:      true,
+		Encoder: func(w io.Writer, img image.Image, q int, p bool) error {
+			quality, progressive = q, p
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+		},
+	}
+	photo, err = pm.SaveSingle(pngUpload(filled(40, 20, color.White), t), pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Type != formatJPEG || photo.ContentType() != "image/jpeg" {
+		t.Errorf("expected %s got %s", formatJPEG, photo.Type)
+	}
+	if quality != 80 || !progressive {
+		t.Errorf("expected quality 80 and progressive got %d %v", quality, progressive)
+	}
+	data, err := pm.GetData(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 10 {
+		t.Errorf("expected 20x10 got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	pm.Encoding.Encoder = nil
+	photo, err = pm.SaveSingle(pngUpload(filled(40, 20, color.Black), t), pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = pm.GetData(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte{0xff, 0xc2}) {
+		t.Error("expected a progressive jpeg")
+	}
+	if _, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+
+	failed := errors.New("encoder failed")
+	pm.Encoding.Encoder = func(io.Writer, image.Image, int, bool) error {
+		return failed
+	}
+	_, err = pm.SaveSingle(pngUpload(filled(40, 20, color.Black), t), pids[0])
+	if !errors.Is(err, failed) {
+		t.Errorf("expected %v got %v", failed, err)
+	}
+}
@@ -0,0 +1,177 @@
+package mrs
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+)
+
+// Error codes sent to clients. They are part of the api, so once added they should
+// never change.
+const (
+	CodeNotFound             = "not_found"
+	CodeProfileNotFound      = "profile_not_found"
+	CodePhotoNotFound        = "photo_not_found"
+	CodeValidation           = "invalid"
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidRevision      = "invalid_revision"
+	CodeMissingFile          = "missing_file"
+	CodeInvalidImage         = "invalid_image"
+	CodeUnsupportedMedia     = "unsupported_media_type"
+	CodeTooLarge             = "too_large"
+	CodeImageTooLarge        = "image_too_large"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeCSRF                 = "csrf_failed"
+	CodeRateLimited          = "rate_limited"
+	CodeLinkExpired          = "link_expired"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeConflict             = "conflict"
+	CodeDuplicatePhoto       = "duplicate_photo"
+	CodeAlreadyReported      = "already_reported"
+	CodePhotoRejected        = "photo_rejected"
+	CodeScanFailed           = "scan_failed"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeInternal             = "internal"
+)
+
+// Error is the error reported by the handlers. It knows the http status code to
+// respond with, and is rendered to clients as
+//
+//	{"status": 404, "code": "profile_not_found", "msg": "sorry: ..."}
+//
+// The Err field holds the underlying error, so errors.Is works with the package
+// errors like ErrProfileNotFound. Errors also match the kinds below which share the
+// same status, so errors.Is(err, ErrNotFound) is true for any 404 error.
+type Error struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Status  int      `json:"status" xml:"status"`
+	Code    string   `json:"code" xml:"code"`
+	Msg     string   `json:"msg" xml:"msg"`
+	Err     error    `json:"-" xml:"-"`
+}
+
+// The kinds of errors reported by the handlers.
+var (
+	ErrNotFound           = &Error{Status: http.StatusNotFound, Code: CodeNotFound, Msg: "not found"}
+	ErrValidation         = &Error{Status: http.StatusBadRequest, Code: CodeValidation, Msg: "invalid request"}
+	ErrUnsupportedMedia   = &Error{Status: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMedia, Msg: "unsupported media type"}
+	ErrTooLarge           = &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge, Msg: "request too large"}
+	ErrMethodNotAllowed   = &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Msg: "method not allowed"}
+	ErrNotAcceptable      = &Error{Status: http.StatusNotAcceptable, Code: CodeNotAcceptable, Msg: "not acceptable"}
+	ErrConflict           = &Error{Status: http.StatusConflict, Code: CodeConflict, Msg: "conflict"}
+	ErrPreconditionFailed = &Error{Status: http.StatusPreconditionFailed, Code: CodePreconditionFailed, Msg: "precondition failed"}
+	ErrInternal           = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Msg: "sorry: something went wrong"}
+)
+
+// newError returns an Error of the given status and code, wrapping err.
+func newError(status int, code string, err error) *Error {
+	return &Error{Status: status, Code: code, Msg: err.Error(), Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an Error of the same kind, that is with the same
+// status code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Status == e.Status
+}
+
+// httpError turns err into an Error. Errors which are not known are reported as
+// fallback, keeping err as the underlying cause without exposing its message.
+func httpError(err error, fallback *Error) *Error {
+	var e *Error
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrProfileNotFound):
+		return newError(http.StatusNotFound, CodeProfileNotFound, ErrProfileNotFound)
+	case errors.Is(err, ErrInvalidProfileID):
+		return newError(http.StatusBadRequest, CodeInvalidID, ErrInvalidProfileID)
+	case errors.Is(err, ErrUnauthorized):
+		return newError(http.StatusUnauthorized, CodeUnauthorized, ErrUnauthorized)
+	case errors.Is(err, ErrForbidden):
+		return newError(http.StatusForbidden, CodeForbidden, ErrForbidden)
+	case errors.Is(err, ErrCSRF):
+		return newError(http.StatusForbidden, CodeCSRF, ErrCSRF)
+	case errors.Is(err, ErrNotModerator):
+		return newError(http.StatusForbidden, CodeForbidden, ErrNotModerator)
+	case errors.Is(err, ErrInvalidSignature):
+		return newError(http.StatusForbidden, CodeForbidden, ErrInvalidSignature)
+	case errors.Is(err, ErrLinkExpired):
+		return newError(http.StatusForbidden, CodeLinkExpired, ErrLinkExpired)
+	case errors.Is(err, ErrPhotoPrivate):
+		return newError(http.StatusForbidden, CodeForbidden, ErrPhotoPrivate)
+	case errors.Is(err, ErrRateLimited):
+		return newError(http.StatusTooManyRequests, CodeRateLimited, ErrRateLimited)
+	case errors.Is(err, ErrPhotoNotFound):
+		return newError(http.StatusNotFound, CodePhotoNotFound, ErrPhotoNotFound)
+	case errors.Is(err, ErrNoFingerprint):
+		return newError(http.StatusConflict, CodeConflict, err)
+	case errors.Is(err, ErrProfileModified):
+		return newError(http.StatusPreconditionFailed, CodePreconditionFailed, ErrProfileModified)
+	case errors.Is(err, ErrPreconditionRequired):
+		return newError(http.StatusPreconditionRequired, CodePreconditionRequired, ErrPreconditionRequired)
+	case errors.Is(err, ErrDuplicatePhoto):
+		return newError(http.StatusConflict, CodeDuplicatePhoto, err)
+	case errors.Is(err, ErrPhotoRejected):
+		return newError(http.StatusUnprocessableEntity, CodePhotoRejected, err)
+	case errors.Is(err, ErrScanFailed):
+		return newError(http.StatusServiceUnavailable, CodeScanFailed, ErrScanFailed)
+	case errors.Is(err, ErrAlreadyReported):
+		return newError(http.StatusConflict, CodeAlreadyReported, ErrAlreadyReported)
+	case errors.Is(err, ErrInvalidReport):
+		return newError(http.StatusBadRequest, CodeValidation, err)
+	case errors.Is(err, ErrUnsupportedFile):
+		return newError(http.StatusUnsupportedMediaType, CodeUnsupportedMedia, err)
+	case errors.Is(err, ErrImageTooLarge):
+		return newError(http.StatusRequestEntityTooLarge, CodeImageTooLarge, err)
+	case errors.Is(err, ErrInvalidImage):
+		return newError(http.StatusBadRequest, CodeInvalidImage, err)
+	case errors.Is(err, ErrInvalidRevision):
+		return newError(http.StatusBadRequest, CodeInvalidRevision, ErrInvalidRevision)
+	case errors.Is(err, ErrInvalidCrop):
+		return newError(http.StatusBadRequest, CodeValidation, ErrInvalidCrop)
+	case errors.Is(err, ErrInvalidPrivacy):
+		return newError(http.StatusBadRequest, CodeValidation, ErrInvalidPrivacy)
+	case errors.Is(err, http.ErrMissingFile):
+		return newError(http.StatusBadRequest, CodeMissingFile, err)
+	case errors.Is(err, ErrQuotaExceeded):
+		return newError(http.StatusInsufficientStorage, CodeQuotaExceeded, err)
+	case errors.As(err, &tooLarge):
+		return newError(http.StatusRequestEntityTooLarge, CodeTooLarge, err)
+	}
+	return &Error{Status: fallback.Status, Code: fallback.Code, Msg: fallback.Msg, Err: err}
+}
+
+// writeError writes e in json, xml or plain text depending on the Accept header. It is
+// used by the middlewares, which unlike the handlers have no renderer.
+func writeError(w http.ResponseWriter, r *http.Request, e *Error) {
+	var data []byte
+	switch negotiate(r, mimeJSON, mimeXML) {
+	case mimeJSON:
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		data, _ = json.Marshal(e)
+	case mimeXML:
+		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+		data, _ = xml.Marshal(e)
+	default:
+		http.Error(w, e.Error(), e.Status)
+		return
+	}
+	w.WriteHeader(e.Status)
+	w.Write(data)
+}
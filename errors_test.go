@@ -0,0 +1,110 @@
+package mrs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestHTTPError(t *testing.T) {
+	sample := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrProfileNotFound, http.StatusNotFound, CodeProfileNotFound},
+		{ErrPhotoNotFound, http.StatusNotFound, CodePhotoNotFound},
+		{ErrProfileModified, http.StatusPreconditionFailed, CodePreconditionFailed},
+		{ErrPreconditionRequired, http.StatusPreconditionRequired, CodePreconditionRequired},
+		{fmt.Errorf("%w: text/plain", ErrUnsupportedFile), http.StatusUnsupportedMediaType, CodeUnsupportedMedia},
+		{fmt.Errorf("%w: bad huffman code", ErrInvalidImage), http.StatusBadRequest, CodeInvalidImage},
+		{http.ErrMissingFile, http.StatusBadRequest, CodeMissingFile},
+		{ErrInvalidRevision, http.StatusBadRequest, CodeInvalidRevision},
+		{fmt.Errorf("%w: abc", ErrNoFingerprint), http.StatusConflict, CodeConflict},
+		{&http.MaxBytesError{Limit: 10}, http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{ErrConflict, http.StatusConflict, CodeConflict},
+		{errors.New("disk on fire"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, v := range sample {
+		e := httpError(v.err, ErrInternal)
+		if e.Status != v.status {
+			t.Errorf("%v: expected %d actual %d", v.err, v.status, e.Status)
+		}
+		if e.Code != v.code {
+			t.Errorf("%v: expected %s actual %s", v.err, v.code, e.Code)
+		}
+		if !errors.Is(e, v.err) {
+			t.Errorf("%v: expected errors.Is to match the cause", v.err)
+		}
+	}
+	e := httpError(ErrProfileNotFound, ErrInternal)
+	if !errors.Is(e, ErrNotFound) {
+		t.Error("Expected profile not found to be of the not found kind")
+	}
+	if errors.Is(e, ErrConflict) {
+		t.Error("Expected profile not found not to be a conflict")
+	}
+	if e := httpError(errors.New("disk on fire"), ErrInternal); strings.Contains(e.Error(), "disk") {
+		t.Errorf("Expected the internal error message to be hidden, got %s", e)
+	}
+}
+
+func TestHandlers_Errors(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.MaxUploadSize = 1024
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+	h.HandleFunc("/profile/picture/{id}", handle.ProfilePic)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wrong method
+	r, _ := http.NewRequest("DELETE", fmt.Sprintf("/profile/%s", pids[0]), nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("Expected GET, HEAD actual %s", allow)
+	}
+	if !strings.Contains(w.Body.String(), CodeMethodNotAllowed) {
+		t.Errorf("Expected %s to contain %s", w.Body.String(), CodeMethodNotAllowed)
+	}
+
+	// not a multipart request
+	r, _ = http.NewRequest("POST", fmt.Sprintf("/profile/picture/%s", pids[0]), strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected %d actual %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+
+	// me.jpg is larger than the limit
+	r = ajaxtWithFile(fmt.Sprintf("/profile/picture/%s", pids[0]), "profile", t)
+	r.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected %d actual %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), CodeTooLarge) {
+		t.Errorf("Expected %s to contain %s", w.Body.String(), CodeTooLarge)
+	}
+}
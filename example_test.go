@@ -0,0 +1,53 @@
+package mrs_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gernest/mrs"
+)
+
+// This example shows an Authenticator adapter for signed cookies. The cookie value is
+// the user id followed by the base64 encoded hmac of the id, which is what the login
+// handler of the application would set.
+func ExampleAuthenticatorFunc() {
+	key := []byte("a very secret key")
+	sign := func(userID string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(userID))
+		return userID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	auth := mrs.AuthenticatorFunc(func(r *http.Request) (*mrs.Identity, error) {
+		c, err := r.Cookie("user")
+		if err != nil {
+			return nil, nil // anonymous
+		}
+		i := strings.LastIndex(c.Value, ".")
+		if i < 0 || !hmac.Equal([]byte(sign(c.Value[:i])), []byte(c.Value)) {
+			return nil, errors.New("bad cookie")
+		}
+		return &mrs.Identity{UserID: c.Value[:i]}, nil
+	})
+
+	h := mrs.NewHandlers("db/media.db", "meta", "data", nil)
+	h.Auth = auth
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: sign("db0668ac-7eba-40dd-96ee-0b1c0b9b415d")})
+	who, err := auth.Authenticate(r)
+	fmt.Println(who.UserID, err)
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: "db0668ac-7eba-40dd-96ee-0b1c0b9b415d.forged"})
+	_, err = auth.Authenticate(r)
+	fmt.Println(err)
+
+	// Output:
+	// db0668ac-7eba-40dd-96ee-0b1c0b9b415d <nil>
+	// bad cookie
+}
@@ -1,9 +1,14 @@
 package mrs
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/unrolled/render"
@@ -12,16 +17,56 @@ import (
 var (
 	// ErrProfileNotFound is the message when the user profile is not found
 	ErrProfileNotFound = errors.New("sorry: the requested profile cannot be found")
+
+	// ErrPreconditionRequired is the message when a request which modifies a profile
+	// lacks the If-Match header.
+	ErrPreconditionRequired = errors.New("sorry: the If-Match header is required")
+
+	// ErrPhotoNotFound is the message when the requested photo is not found
+	ErrPhotoNotFound = errors.New("sorry: the requested photo cannot be found")
 )
 
 // Handlers user profile centric handlers
 type Handlers struct {
 	pm    *PhotoManager
 	rendr *render.Render
-}
 
-type jsonErr struct {
-	Msg string `json:"msg"`
+	// Cache is the caching policy applied to the responses.
+	Cache CachePolicy
+
+	// IDFormat is the format of the profile ids accepted in the url path, requests
+	// with malformed ids are rejected with 400 Bad Request.
+	IDFormat IDFormat
+
+	// IDs generates the ids of profiles created with Create, the ids must be valid
+	// according to IDFormat.
+	IDs IDGenerator
+
+	// Auth resolves the caller of requests. When it is nil, no authentication is done
+	// and anyone can modify any profile.
+	Auth Authenticator
+
+	// Policy decides who can modify a profile, it defaults to OwnerPolicy. It is only
+	// consulted when Auth is set.
+	Policy Policy
+
+	// Relations resolves how close the caller of a request is to the owner of a
+	// profile, which decides the fields the caller can see. It defaults to
+	// DefaultRelations.
+	Relations Relations
+
+	// Signer verifies signed photo urls, which let private photos be shared with
+	// anyone holding the link. Signed urls are rejected when it is nil.
+	Signer *URLSigner
+
+	// RateLimits limits how often Update, ProfilePic and FileUploads can be called
+	// for a profile from the same client.
+	RateLimits RateLimits
+
+	// MaxUploadSize is the maximum size in bytes of the body of upload requests,
+	// larger requests are rejected with 413 Request Entity Too Large. Zero means
+	// no limit.
+	MaxUploadSize int64
 }
 
 // NewHandlers initialize a new Handlers instance.
@@ -30,104 +75,616 @@ func NewHandlers(db, meta, data string, opt *render.Options) *Handlers {
 	if opt != nil {
 		r = render.New(*opt)
 	}
-	return &Handlers{pm: NewPhotoManager(db, meta, data), rendr: r}
+	return &Handlers{
+		pm:            NewPhotoManager(db, meta, data),
+		rendr:         r,
+		Cache:         DefaultCachePolicy,
+		IDFormat:      UUIDv4,
+		IDs:           UUIDv4,
+		RateLimits:    RateLimits{Store: NewMemoryRateStore()},
+		MaxUploadSize: defaultMaxUploadSize,
+	}
+}
+
+// Photos returns the PhotoManager of the handlers, to configure things like the
+// quota of the profiles.
+func (h *Handlers) Photos() *PhotoManager {
+	return h.pm
 }
 
 // Home handles the profile home page. It expects in the url path to have the param
 // id which is a uuid v4 string.using gorilla mux the url  should be as follows.
+//
 //	/profile/{id:^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$}
+//
+// The route constraint is not relied upon, all the handlers validate the id against
+// the IDFormat and respond with 400 Bad Request when it is malformed.
+//
+// The profile is rendered as html, json or xml depending on the Accept header, html
+// being the default. The profile_home template gets the profile and the CSRF token as
+// profile and csrf_token respectively.
+//
+// Only the fields which the caller is allowed to see according to the privacy
+// settings of the profile are rendered, the profile is a *ProfileView.
+//
+// Responses carry the ETag and Last-Modified validators, conditional requests are
+// answered with 304 Not Modified when the profile hasn't changed.
 func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	pid := vars["id"]
-	if r.Method == "GET" {
-		p, err := NewProfile(pid).Get()
-		if h.isAjax(r) {
-			if err != nil {
-				h.rendr.JSON(w, http.StatusNotFound, &jsonErr{Msg: ErrProfileNotFound.Error()})
-				return
-			}
-			h.rendr.JSON(w, http.StatusOK, p)
-			return
-		}
-		data := make(map[string]interface{})
-		if err != nil {
-			data["error"] = ErrProfileNotFound
-			h.rendr.HTML(w, http.StatusNotFound, "404", data)
-			return
-		}
-		data["profile"] = p
-		h.rendr.HTML(w, http.StatusOK, "profile_home", data)
+	w.Header().Set("Vary", "Accept, X-Requested-With, Authorization, Cookie")
+	format := negotiate(r, mimeHTML, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeHTML, mimeJSON, mimeXML)
 		return
 	}
+	if !h.allowMethod(w, r, format, "GET", "HEAD") {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	w.Header().Set("Cache-Control", h.Cache.Profile)
+	v := h.view(r, p)
+	if notModified(w, r, profileETag(v, format), profileModified(p)) {
+		return
+	}
+	if format != mimeHTML {
+		h.render(w, format, http.StatusOK, v)
+		return
+	}
+	data := make(map[string]interface{})
+	data["profile"] = v
+	data["csrf_token"] = CSRFToken(r)
+	h.rendr.HTML(w, http.StatusOK, "profile_home", data)
+}
+
+// Create handles creation of new profiles, the id of the profile is generated on the
+// server with the IDs generator. The request body is an optional json encoded profile
+// with the initial values of the fields the owner is allowed to change.
+//
+// The created profile is sent back as json or xml with 201 Created.
+func (h *Handlers) Create(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return
+	}
+	form := new(Profile)
+	err := json.NewDecoder(r.Body).Decode(form)
+	if err != nil && err != io.EOF {
+		h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("bad profile data")))
+		return
+	}
+	id, err := NewProfileID(h.IDs, h.IDFormat)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if err = validPrivacy(form.Privacy); err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	p := NewProfile(id)
+	p.edit(form)
+	if who != nil {
+		p.Owner = who.UserID
+	}
+	err = p.Create()
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	v := h.view(r, p)
+	w.Header().Set("ETag", profileETag(v, format))
+	h.render(w, format, http.StatusCreated, v)
 }
 
-// ProfilePic hadles fileupload for a profile picture. This is inteded to work in
-// ajax only requests.
+// Update handles modification of the profile fields. The request body is a json
+// encoded profile, only the fields which the owner is allowed to change are used.
+// The updated profile is sent back as json or xml.
+//
+// The If-Match header must carry the ETag returned by Home, if the profile has been
+// modified in the meantime the request fails with 412 Precondition Failed.
+func (h *Handlers) Update(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "PUT", "POST") {
+		return
+	}
+	if !h.allowRate(w, r, format, "update", h.RateLimits.Update) {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if !h.authorize(w, r, format, who, p) {
+		return
+	}
+	if err = h.ifMatch(r, p); err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	form := new(Profile)
+	err = json.NewDecoder(r.Body).Decode(form)
+	if err != nil {
+		h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("bad profile data")))
+		return
+	}
+	if err = validPrivacy(form.Privacy); err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	p.edit(form)
+	err = p.UpdateIf(p.Version)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	v := h.view(r, p)
+	w.Header().Set("ETag", profileETag(v, format))
+	h.render(w, format, http.StatusOK, v)
+}
+
+// ProfilePic hadles fileupload for a profile picture. The updated profile is sent
+// back as json or xml. Since it changes the profile, the If-Match header is required
+// just like in Update.
+//
+// The square renditions of the picture are cropped according to the optional crop
+// and focus form fields, see ParseCrop. Without them the square is centered.
 func (h *Handlers) ProfilePic(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	if !h.allowRate(w, r, format, "profile_pic", h.RateLimits.ProfilePic) {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok || !h.allowUpload(w, r, format) {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if !h.authorize(w, r, format, who, p) {
+		return
+	}
+	if err = h.ifMatch(r, p); err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	up, err := h.pm.GetSingleFileUpload(r, "profile")
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	crop, err := ParseCrop(r.FormValue("crop"), r.FormValue("focus"))
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	pic, err := h.pm.SaveSingle(up, p.ID)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	pic, err = h.pm.Crop(pic.ID, crop)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	p.Picture = pic.ID
+	err = p.UpdateIf(p.Version)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	v := h.view(r, p)
+	w.Header().Set("ETag", profileETag(v, format))
+	h.render(w, format, http.StatusOK, v)
+}
+
+// Crop crops again a photo of the profile whose id is in the url path, and generates
+// new square renditions. The request body is json with the crop and focus parameters
+// of ProfilePic, and the id of the photo in the photo field which defaults to the
+// profile picture, like {"photo":"...","focus":"0.5,0.3"}.
+//
+// The cropped photo is sent back as json or xml, the revision of its crop tells the
+// urls of the new renditions.
+func (h *Handlers) Crop(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	if !h.allowRate(w, r, format, "crop", h.RateLimits.ProfilePic) {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if !h.authorize(w, r, format, who, p) {
+		return
+	}
+	form := struct {
+		Photo string `json:"photo"`
+		Crop  string `json:"crop"`
+		Focus string `json:"focus"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&form)
+	if err != nil {
+		h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("bad crop data")))
+		return
+	}
+	crop, err := ParseCrop(form.Crop, form.Focus)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	id := form.Photo
+	if id == "" {
+		id = p.Picture
+	}
+	photo, err := h.pm.Get(id)
+	if err == nil && photo.UploadedBy != p.ID {
+		err = ErrPhotoNotFound
+	}
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	photo, err = h.pm.Crop(photo.ID, crop)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	h.render(w, format, http.StatusOK, photo)
+}
+
+// Photo serves the data of the photo whose id is given in the url path. The response
+// is cached according to the Photo field of the caching policy, conditional and range
+// requests are supported, so large photos can be fetched partially or resumed.
+//
+// The square renditions of cropped photos are served with the rendition and rev query
+// parameters, like ?rendition=square-128&rev=2 where rev is the revision of the crop.
+// Since cropping again bumps the revision, the url of a rendition never changes
+// content and it is cached according to the Rendition field of the caching policy.
+// Without rev the current crop is served, and caches have to revalidate it on every
+// use.
+//
+// Photos of profiles whose photos field is not public are private, they are only
+// served to viewers allowed to see the field, or through a url signed by the Signer.
+// Private photos are never cached by shared caches, and responses to signed urls are
+// not cached past the expiry of the link.
+//
+// Photos which are not approved, see Scanner, are only served to their owner, others
+// get a 404 as if the photo didn't exist.
+//
+// The data is streamed straight from the database without loading whole images into
+// memory. The database stays open for reading while it is sent, writers wait for it
+// up to a timeout, after which they fail instead of hanging.
+func (h *Handlers) Photo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	pid := vars["id"]
-	if r.Method == "POST" {
-		p, err := NewProfile(pid).Get()
-		if h.isAjax(r) {
-			if err != nil {
-				h.rendr.JSON(w, http.StatusOK, &jsonErr{Msg: ErrProfileNotFound.Error()})
-				return
+	id := vars["id"]
+	errFormat := negotiate(r, mimeHTML, mimeJSON, mimeXML)
+	if !h.allowMethod(w, r, errFormat, "GET", "HEAD") {
+		return
+	}
+	photo, err := h.pm.Get(id)
+	if err != nil {
+		h.renderError(w, errFormat, httpError(err, ErrInternal))
+		return
+	}
+	rendition, cache, err := h.photoAccess(r, photo)
+	if err != nil {
+		h.renderError(w, errFormat, httpError(err, ErrInternal))
+		return
+	}
+	if negotiate(r, photo.ContentType()) == "" {
+		notAcceptable(w, r, photo.ContentType())
+		return
+	}
+	revision := 0
+	if rendition != RenditionOriginal {
+		rev := r.URL.Query().Get("rev")
+		switch {
+		case rev == "" && photo.Crop != nil:
+			// not pinned to a crop, the rendition changes when the photo is cropped.
+			revision = photo.Crop.Revision
+			if cache == h.Cache.Photo {
+				cache = "no-cache"
+			} else {
+				cache = "private, no-cache"
 			}
-			if h.isUpload(r) {
-				up, err := h.pm.GetSingleFileUpload(r, "profile")
-				if err != nil {
-					h.rendr.JSON(w, http.StatusOK, &jsonErr{Msg: "trouble saving"})
-					return
-				}
-				pic, err := h.pm.SaveSingle(up, p.ID)
-				if err != nil {
-					h.rendr.JSON(w, http.StatusNotFound, &jsonErr{Msg: "trouble saving"})
-					return
-				}
-				p.Picture = pic.ID
-				err = p.Update()
-				if err != nil {
-					// TODO (gernest): log this error
-				}
-				h.rendr.JSON(w, http.StatusOK, p)
+		case rev != "":
+			revision, err = strconv.Atoi(rev)
+			if err != nil || revision < 1 {
+				h.renderError(w, errFormat, httpError(ErrInvalidRevision, ErrInternal))
 				return
 			}
+			if cache == h.Cache.Photo && h.Cache.Rendition != "" {
+				cache = h.Cache.Rendition
+			}
+		}
+	}
+	err = h.pm.ReadRendition(photo, rendition, revision, func(data io.ReadSeeker) error {
+		etag, err := renditionETag(photo, rendition, revision, data)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Cache-Control", cache)
+		w.Header().Set("Content-Type", photo.ContentType())
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", photo.UpdatedAt, data)
+		return nil
+	})
+	if err != nil {
+		h.renderError(w, errFormat, httpError(err, ErrInternal))
+	}
+}
 
+// photoAccess checks that the caller of the request can see the photo, either through
+// a signed url or according to the privacy settings of the profile the photo belongs
+// to. It returns the requested rendition and the Cache-Control of the response.
+func (h *Handlers) photoAccess(r *http.Request, photo *Photo) (string, string, error) {
+	q := r.URL.Query()
+	if Signed(q) {
+		if h.Signer == nil {
+			return "", "", ErrInvalidSignature
 		}
+		viewer := ""
+		if who, _ := h.identity(r); who != nil {
+			viewer = who.UserID
+		}
+		rendition, expires, err := h.Signer.Verify(q, photo.ID, viewer)
+		if err != nil {
+			return "", "", err
+		}
+		if !photo.Approved() {
+			return "", "", ErrPhotoNotFound
+		}
+		return rendition, fmt.Sprintf("private, max-age=%d", int(time.Until(expires)/time.Second)), nil
+	}
+	rendition := q.Get("rendition")
+	if rendition == "" {
+		rendition = RenditionOriginal
+	}
+	id, err := ParseProfileIDFormat(photo.UploadedBy, h.IDFormat)
+	if err != nil {
+		// the photo doesn't belong to a profile.
+		return rendition, h.Cache.Photo, approved(photo)
+	}
+	p, err := NewProfile(id).Get()
+	if errors.Is(err, ErrProfileNotFound) {
+		return rendition, h.Cache.Photo, approved(photo)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if !photo.Approved() {
+		// only the owner sees the photo until it is approved.
+		if h.relationship(r, p) != Private {
+			return "", "", ErrPhotoNotFound
+		}
+		return rendition, "private, no-cache", nil
+	}
+	field := p.Visibility("photos")
+	if photo.ID == p.Picture || field == Public {
+		return rendition, h.Cache.Photo, nil
+	}
+	if !h.relationship(r, p).Allows(field) {
+		return "", "", ErrPhotoPrivate
+	}
+	return rendition, "private, no-cache", nil
+}
+
+// approved returns ErrPhotoNotFound if the photo is not approved.
+func approved(photo *Photo) error {
+	if !photo.Approved() {
+		return ErrPhotoNotFound
+	}
+	return nil
+}
+
+// Usage sends the storage used by the photos of the profile whose id is in the url
+// path, along with the quota, as json or xml. Like the handlers modifying a profile,
+// only callers allowed by the Policy can see it.
+func (h *Handlers) Usage(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "GET", "HEAD") {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if !h.authorize(w, r, format, who, p) {
+		return
 	}
+	u, err := h.pm.Usage(p.ID)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.render(w, format, http.StatusOK, u)
 }
 
-// FileUploads handlers multiple file uploads by a given user.
+// FileUploads handlers multiple file uploads by a given user. The saved photos are
+// sent back as json or xml.
 func (h *Handlers) FileUploads(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	pid := vars["id"]
-	if r.Method == "POST" {
-		p, err := NewProfile(pid).Get()
-		if h.isAjax(r) {
-			if err != nil {
-				h.rendr.JSON(w, http.StatusOK, &jsonErr{Msg: ErrProfileNotFound.Error()})
-				return
-			}
-			if h.isUpload(r) {
-				up, err := h.pm.GetUploadFiles(r, "photos")
-				if err != nil {
-					h.rendr.JSON(w, http.StatusOK, &jsonErr{Msg: "trouble saving"})
-					return
-				}
-				ups, err := h.pm.SaveMultiple(up, p.ID)
-				if err != nil {
-					h.rendr.JSON(w, http.StatusOK, &jsonErr{Msg: "trouble saving"})
-					return
-				}
-				h.rendr.JSON(w, http.StatusOK, ups)
-				return
-			}
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	if !h.allowRate(w, r, format, "file_uploads", h.RateLimits.FileUploads) {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok || !h.allowUpload(w, r, format) {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if !h.authorize(w, r, format, who, p) {
+		return
+	}
+	up, err := h.pm.GetUploadFiles(r, "photos")
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrValidation))
+		return
+	}
+	ups, err := h.pm.SaveMultiple(up, p.ID)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	h.render(w, format, http.StatusOK, ups)
+}
+
+// profileID parses the id param of the url path.
+func (h *Handlers) profileID(r *http.Request) (ProfileID, error) {
+	return ParseProfileIDFormat(mux.Vars(r)["id"], h.IDFormat)
+}
+
+// getProfile retrieves the profile whose id is in the url path.
+func (h *Handlers) getProfile(r *http.Request) (*Profile, error) {
+	id, err := h.profileID(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewProfile(id).Get()
+}
+
+// render writes v in the negotiated format, which is either json or xml.
+func (h *Handlers) render(w http.ResponseWriter, format string, status int, v interface{}) {
+	if format == mimeXML {
+		h.rendr.XML(w, status, v)
+		return
+	}
+	h.rendr.JSON(w, status, v)
+}
+
+// renderError writes the error in the negotiated format. Html errors are rendered
+// with the 404 template.
+func (h *Handlers) renderError(w http.ResponseWriter, format string, err *Error) {
+	switch format {
+	case mimeHTML:
+		h.rendr.HTML(w, err.Status, "404", map[string]interface{}{"error": err})
+	case mimeJSON, mimeXML:
+		h.render(w, format, err.Status, err)
+	default:
+		http.Error(w, err.Error(), err.Status)
+	}
+}
 
+// allowMethod checks that the request method is one of methods, if not it responds
+// with 405 Method Not Allowed and returns false.
+func (h *Handlers) allowMethod(w http.ResponseWriter, r *http.Request, format string, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
 		}
 	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	h.renderError(w, format, ErrMethodNotAllowed)
+	return false
 }
-func (h *Handlers) isAjax(r *http.Request) bool {
-	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+
+// allowUpload checks that the request is a multipart upload, if not it responds with
+// 415 Unsupported Media Type and returns false. The request body is limited to
+// MaxUploadSize.
+func (h *Handlers) allowUpload(w http.ResponseWriter, r *http.Request, format string) bool {
+	if !h.isUpload(r) {
+		h.renderError(w, format, newError(http.StatusUnsupportedMediaType, CodeUnsupportedMedia,
+			errors.New("sorry: expected a multipart/form-data request")))
+		return false
+	}
+	if h.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize)
+	}
+	return true
+}
+
+// ifMatch checks the If-Match header of the request against the current version
+// of the profile, as seen by the caller.
+func (h *Handlers) ifMatch(r *http.Request, p *Profile) error {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return ErrPreconditionRequired
+	}
+	v := h.view(r, p)
+	for _, format := range []string{mimeJSON, mimeHTML, mimeXML} {
+		if matchETag(match, profileETag(v, format), false) {
+			return nil
+		}
+	}
+	return ErrProfileModified
+}
+
+// profileETag returns a strong entity tag for the given representation of the
+// profile. The tag is derived from the content of the view, which includes the
+// version, so it changes whenever the profile is saved, and viewers who see
+// different fields get different tags.
+func profileETag(v *ProfileView, variant string) string {
+	data, _ := json.Marshal(v)
+	return contentETag([]byte(variant), data)
+}
+
+// profileModified returns the last time the profile was changed.
+func profileModified(p *Profile) time.Time {
+	if p.UpdatedAt.IsZero() {
+		return p.CreatedAt
+	}
+	return p.UpdatedAt
 }
 
 func (h *Handlers) isUpload(r *http.Request) bool {
@@ -17,9 +17,9 @@ import (
 
 var (
 	pids = []string{
-		"db0668ac-7eba-40dd-56ee-0b1c0b9b415d",
-		"e6917dfe-b4f6-49b8-5628-83dd2a430e9a",
-		"bc5288cf-4120-4f3c-5957-b19e093a12f4",
+		"db0668ac-7eba-40dd-96ee-0b1c0b9b415d",
+		"e6917dfe-b4f6-49b8-9628-83dd2a430e9a",
+		"bc5288cf-4120-4f3c-9957-b19e093a12f4",
 	}
 )
 
@@ -76,7 +76,7 @@ func TestHandlers_Home(t *testing.T) {
 	// create the test profiles
 	defer cleanUp()
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
@@ -141,20 +141,29 @@ func TestHandlers_ProfilePic(t *testing.T) {
 	if req != nil {
 		w := httptest.NewRecorder()
 		h.ServeHTTP(w, req)
-		if w.Code != http.StatusOK {
-			t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected %d actual %d", http.StatusNotFound, w.Code)
 		}
 		if !strings.Contains(w.Body.String(), ErrProfileNotFound.Error()) {
 			t.Errorf("Expected %s to contain %s", w.Body.String(), ErrProfileNotFound.Error())
 		}
 
 		// Create a new profile and try again
-		profile := NewProfile(pids[0])
+		profile := NewProfile(MustParseProfileID(pids[0]))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
 		}
 		if err == nil {
+			// the If-Match header is required
+			w1 := httptest.NewRecorder()
+			h.ServeHTTP(w1, req)
+			if w1.Code != http.StatusPreconditionRequired {
+				t.Errorf("Expected %d actual %d", http.StatusPreconditionRequired, w1.Code)
+			}
+
+			stored, _ := NewProfile(MustParseProfileID(pids[0])).Get()
+			req.Header.Set("If-Match", profileETag(stored.View(Public), mimeJSON))
 			w2 := httptest.NewRecorder()
 			h.ServeHTTP(w2, req)
 			if w2.Code != http.StatusOK {
@@ -163,24 +172,270 @@ func TestHandlers_ProfilePic(t *testing.T) {
 			if !strings.Contains(w2.Body.String(), profile.ID) {
 				t.Errorf("Expected %s to contain %s", w2.Body.String(), profile.ID)
 			}
+			stored, _ = NewProfile(MustParseProfileID(pids[0])).Get()
+			view := stored.View(Public)
+			if pic, err := handle.pm.Get(stored.Picture); err == nil {
+				view.PicturePlaceholder = pic.Placeholder
+			}
+			if etag := w2.Header().Get("ETag"); etag != profileETag(view, mimeJSON) {
+				t.Errorf("Expected %s actual %s", profileETag(view, mimeJSON), etag)
+			}
 		}
 
 	}
 	// There is np such field name
 	req2 := ajaxtWithFile(fmt.Sprintf("%s%s", bPath, pids[0]), "profile_pic", t)
 	if req2 != nil {
+		req2.Header.Set("If-Match", "*")
 		w := httptest.NewRecorder()
 		h.ServeHTTP(w, req2)
-		if w.Code != http.StatusOK {
-			t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %d actual %d", http.StatusBadRequest, w.Code)
 		}
-		if !strings.Contains(w.Body.String(), "trouble saving") {
-			t.Errorf("Expected %s to contain trouble saving", w.Body.String())
+		if !strings.Contains(w.Body.String(), CodeMissingFile) {
+			t.Errorf("Expected %s to contain %s", w.Body.String(), CodeMissingFile)
 		}
 	}
 
 }
 
+func TestHandlers_Update(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+	h.HandleFunc("/profile/update/{id}", handle.Update)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[1]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", pids[1]), nil)
+	r.Header.Set("X-Requested-With", "XMLHttpRequest")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Error("Expected an ETag")
+	}
+
+	update := func(city, match string) *httptest.ResponseRecorder {
+		body := strings.NewReader(fmt.Sprintf(`{"city":"%s"}`, city))
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/profile/update/%s", pids[1]), body)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		if match != "" {
+			req.Header.Set("If-Match", match)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if w = update("mwanza", ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected %d actual %d", http.StatusPreconditionRequired, w.Code)
+	}
+
+	// the first tab wins
+	if w = update("mwanza", etag); w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	if e := w.Header().Get("ETag"); e == etag {
+		t.Errorf("Expected the ETag to change from %s", etag)
+	}
+
+	// the second tab has a stale ETag
+	w = update("dar es salaam", etag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected %d actual %d", http.StatusPreconditionFailed, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrProfileModified.Error()) {
+		t.Errorf("Expected %s to contain %s", w.Body.String(), ErrProfileModified)
+	}
+	p, err := NewProfile(MustParseProfileID(pids[1])).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.City != "mwanza" {
+		t.Errorf("Expected mwanza actual %s", p.City)
+	}
+}
+
+func TestHandlers_HomeConditional(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/profile/%s", pids[0])
+
+	r, _ := http.NewRequest("GET", path, nil)
+	r.Header.Set("X-Requested-With", "XMLHttpRequest")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	modified := w.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("Expected validators, got ETag %q Last-Modified %q", etag, modified)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != DefaultCachePolicy.Profile {
+		t.Errorf("Expected %s actual %s", DefaultCachePolicy.Profile, cc)
+	}
+
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected %d actual %d", http.StatusNotModified, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected empty body got %s", w.Body.String())
+	}
+
+	// the html representation has its own tag
+	rh, _ := http.NewRequest("GET", path, nil)
+	rh.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, rh)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+
+	r.Header.Del("If-None-Match")
+	r.Header.Set("If-Modified-Since", modified)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected %d actual %d", http.StatusNotModified, w.Code)
+	}
+
+	// once updated, the old validators are stale
+	profile.City = "mwanza"
+	err = profile.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestHandlers_Photo(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	req, err := requestWithFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := handle.pm.GetSingleFileUpload(req, "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := handle.pm.SaveSingle(up, pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/photo/%s", photo.ID)
+
+	r, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	if w.Body.Len() != photo.Size {
+		t.Errorf("Expected %d actual %d", photo.Size, w.Body.Len())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Expected image/jpeg actual %s", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != DefaultCachePolicy.Photo {
+		t.Errorf("Expected %s actual %s", DefaultCachePolicy.Photo, cc)
+	}
+
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected %d actual %d", http.StatusNotModified, w.Code)
+	}
+
+	r, _ = http.NewRequest("GET", "/photo/"+pids[1], nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d actual %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandlers_PhotoRange(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	req, err := requestWithFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := handle.pm.GetSingleFileUpload(req, "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := handle.pm.SaveSingle(up, pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/photo/%s", photo.ID)
+
+	sample := []struct {
+		rng    string
+		code   int
+		ctype  string
+		length int
+	}{
+		{"bytes=0-99", http.StatusPartialContent, "image/jpeg", 100},
+		{fmt.Sprintf("bytes=-%d", 10), http.StatusPartialContent, "image/jpeg", 10},
+		{"bytes=0-9,20-29", http.StatusPartialContent, "multipart/byteranges", -1},
+		{fmt.Sprintf("bytes=%d-", photo.Size+10), http.StatusRequestedRangeNotSatisfiable, "", -1},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Range", v.rng)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: expected %d actual %d", v.rng, v.code, w.Code)
+		}
+		if v.ctype != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), v.ctype) {
+			t.Errorf("%s: expected %s actual %s", v.rng, v.ctype, w.Header().Get("Content-Type"))
+		}
+		if v.length >= 0 && w.Body.Len() != v.length {
+			t.Errorf("%s: expected %d actual %d", v.rng, v.length, w.Body.Len())
+		}
+	}
+}
+
 func TestHandlers_FileUploads(t *testing.T) {
 	opts := render.Options{Directory: "fixture"}
 	handle := NewHandlers("imgs.db", "meta", "data", &opts)
@@ -197,14 +452,14 @@ func TestHandlers_FileUploads(t *testing.T) {
 	if req != nil {
 		w := httptest.NewRecorder()
 		h.ServeHTTP(w, req)
-		if w.Code != http.StatusOK {
-			t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected %d actual %d", http.StatusNotFound, w.Code)
 		}
 		if !strings.Contains(w.Body.String(), ErrProfileNotFound.Error()) {
 			t.Errorf("Expected %s to contain %s", w.Body.String(), ErrProfileNotFound.Error())
 		}
 		// Create a new profile and try again
-		profile := NewProfile(pids[2])
+		profile := NewProfile(MustParseProfileID(pids[2]))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
@@ -224,11 +479,11 @@ func TestHandlers_FileUploads(t *testing.T) {
 		if req2 != nil {
 			w := httptest.NewRecorder()
 			h.ServeHTTP(w, req2)
-			if w.Code != http.StatusOK {
-				t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected %d actual %d", http.StatusBadRequest, w.Code)
 			}
-			if !strings.Contains(w.Body.String(), "trouble saving") {
-				t.Errorf("Expected %s to contain trouble saving", w.Body.String())
+			if !strings.Contains(w.Body.String(), CodeMissingFile) {
+				t.Errorf("Expected %s to contain %s", w.Body.String(), CodeMissingFile)
 			}
 		}
 	}
@@ -297,3 +552,30 @@ func ajaxWithMultipleFiles(path, fname string, t *testing.T) *http.Request {
 	}
 	return nil
 }
+
+// ajaxtWithFields is like ajaxtWithFile, with the profile picture field, but also sets
+// the given form fields.
+func ajaxtWithFields(path string, fields map[string]string, t *testing.T) *http.Request {
+	buf := new(bytes.Buffer)
+	f, err := ioutil.ReadFile("me.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := multipart.NewWriter(buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	ww, err := w.CreateFormFile("profile", "me.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ww.Write(f)
+	w.Close()
+	req, err := http.NewRequest("POST", path, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	return req
+}
@@ -0,0 +1,103 @@
+package mrs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidProfileID is returned when parsing a malformed profile id.
+	ErrInvalidProfileID = errors.New("sorry: invalid profile id")
+)
+
+// IDFormat decides which strings are acceptable profile ids. The default format is
+// UUIDv4, see IDScheme for the other built in formats.
+type IDFormat interface {
+	Valid(id string) bool
+}
+
+// IDFormatFunc is an adapter which allows ordinary functions to be used as IDFormat.
+type IDFormatFunc func(id string) bool
+
+// Valid calls f(id).
+func (f IDFormatFunc) Valid(id string) bool {
+	return f(id)
+}
+
+// ProfileID is a validated profile id. Since every profile resides in its own
+// database named after the id, the only way to get a ProfileID is by parsing, so a
+// raw string coming from a request can never end up in a file path.
+type ProfileID struct {
+	id string
+}
+
+// ParseProfileID parses s as a uuid v4 profile id.
+func ParseProfileID(s string) (ProfileID, error) {
+	return ParseProfileIDFormat(s, UUIDv4)
+}
+
+// ParseProfileIDFormat parses s as a profile id of the given format. Whatever the
+// format says, ids which are not a single clean path element, like ../x or a/b are
+// rejected.
+func ParseProfileIDFormat(s string, format IDFormat) (ProfileID, error) {
+	if s == "" || len(s) > 255 || s == "." || s == ".." ||
+		strings.ContainsAny(s, "/\\\x00") || filepath.Base(s) != s || !format.Valid(s) {
+		return ProfileID{}, ErrInvalidProfileID
+	}
+	return ProfileID{id: s}, nil
+}
+
+// MustParseProfileID is like ParseProfileID but panics if s is not valid. It is meant
+// for ids which are known to be good, like constants.
+func MustParseProfileID(s string) ProfileID {
+	id, err := ParseProfileID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// String returns the id as a string.
+func (id ProfileID) String() string {
+	return id.id
+}
+
+// IsZero reports whether id is the zero value, which is not a valid id.
+func (id ProfileID) IsZero() bool {
+	return id.id == ""
+}
+
+// dbPath returns the path of the database in which the profile resides.
+func (id ProfileID) dbPath() string {
+	if id.IsZero() {
+		return ""
+	}
+	return filepath.Join("db", id.id+".db")
+}
+
+// ProfileIDs returns the ids of the profiles which have a database in the db
+// directory, sorted. Files whose name is not an id of the given format are skipped.
+func ProfileIDs(format IDFormat) ([]ProfileID, error) {
+	entries, err := os.ReadDir("db")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []ProfileID
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".db" {
+			continue
+		}
+		id, err := ParseProfileIDFormat(strings.TrimSuffix(name, ".db"), format)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,111 @@
+package mrs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestParseProfileID(t *testing.T) {
+	for _, id := range pids {
+		pid, err := ParseProfileID(id)
+		if err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+		if pid.String() != id {
+			t.Errorf("Expected %s actual %s", id, pid)
+		}
+		if p := NewProfile(pid); p.path != "db/"+id+".db" {
+			t.Errorf("Expected db/%s.db actual %s", id, p.path)
+		}
+	}
+	bad := []string{
+		"",
+		"..",
+		"../db0668ac-7eba-40dd-96ee-0b1c0b9b415d",
+		"db0668ac-7eba-40dd-96ee-0b1c0b9b415d/..",
+		"db0668ac-7eba-40dd-56ee-0b1c0b9b415d",
+		"DB0668AC-7EBA-40DD-96EE-0B1C0B9B415D",
+		"db0668ac-7eba-40dd-96ee-0b1c0b9b415d\x00",
+	}
+	for _, id := range bad {
+		_, err := ParseProfileID(id)
+		if err != ErrInvalidProfileID {
+			t.Errorf("%q: expected %v actual %v", id, ErrInvalidProfileID, err)
+		}
+	}
+
+	// custom formats can't escape the db directory either
+	anything := IDFormatFunc(func(string) bool { return true })
+	if _, err := ParseProfileIDFormat("gernest", anything); err != nil {
+		t.Error(err)
+	}
+	for _, id := range []string{"../gernest", "a/b", `a\b`, "."} {
+		_, err := ParseProfileIDFormat(id, anything)
+		if err != ErrInvalidProfileID {
+			t.Errorf("%q: expected %v actual %v", id, ErrInvalidProfileID, err)
+		}
+	}
+}
+
+func TestHandlers_InvalidID(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id:.+}", handle.Home)
+
+	for _, id := range []string{"..%2f..%2fetc%2fpasswd", "../../outside", "not-a-uuid"} {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", id), nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d actual %d", id, http.StatusBadRequest, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), CodeInvalidID) {
+			t.Errorf("%s: expected %s to contain %s", id, w.Body.String(), CodeInvalidID)
+		}
+	}
+}
+
+func TestProfileIDs(t *testing.T) {
+	cleanUp()
+	defer cleanUp()
+	ids, err := ProfileIDs(UUIDv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected no profiles got %v", ids)
+	}
+	for _, id := range pids {
+		if err = NewProfile(MustParseProfileID(id)).Create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile("db/media.db", nil, 0600)
+	ioutil.WriteFile("db/notes.txt", nil, 0600)
+
+	ids, err = ProfileIDs(UUIDv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string(nil), pids...)
+	sort.Strings(want)
+	if len(ids) != len(want) {
+		t.Fatalf("expected %v got %v", want, ids)
+	}
+	for i, id := range ids {
+		if id.String() != want[i] {
+			t.Errorf("expected %s got %s", want[i], id)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package mrs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	u "github.com/nu7hatch/gouuid"
+)
+
+// IDGenerator generates unique ids for photos and profiles.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDScheme is an IDGenerator which also knows how to validate the ids it generates,
+// so it can be used both to create profiles and to parse their ids.
+type IDScheme interface {
+	IDGenerator
+	IDFormat
+}
+
+var (
+	// UUIDv4 generates random uuid v4 ids. They don't carry any order.
+	UUIDv4 IDScheme = uuidV4{}
+
+	// UUIDv7 generates uuid v7 ids, which start with a millisecond timestamp. Ids
+	// generated by the same process are strictly increasing, so keys sort by creation
+	// time in bolt buckets.
+	UUIDv7 IDScheme = &uuidV7{}
+
+	// ULID generates ulids, which like UUIDv7 are ordered by creation time, but are
+	// shorter since they are encoded in Crockford's base32.
+	ULID IDScheme = &ulid{}
+)
+
+// NewProfileID generates a new profile id with ids. The generated id must be valid
+// according to format, which is what the handlers will use to parse it back.
+func NewProfileID(ids IDGenerator, format IDFormat) (ProfileID, error) {
+	raw, err := ids.NewID()
+	if err != nil {
+		return ProfileID{}, err
+	}
+	id, err := ParseProfileIDFormat(raw, format)
+	if err != nil {
+		return ProfileID{}, fmt.Errorf("mrs: generated profile id %q: %w", raw, err)
+	}
+	return id, nil
+}
+
+type uuidV4 struct{}
+
+var uuidV4Format = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func (uuidV4) NewID() (string, error) {
+	uuid, err := u.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return uuid.String(), nil
+}
+
+func (uuidV4) Valid(id string) bool {
+	return uuidV4Format.MatchString(id)
+}
+
+// uuidV7 implements the first method of rfc 9562 for monotonicity, the 12 bits
+// following the timestamp are a counter which is seeded randomly every millisecond.
+type uuidV7 struct {
+	mu  sync.Mutex
+	ms  uint64
+	seq uint16
+}
+
+var uuidV7Format = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func (g *uuidV7) NewID() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[6:])
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if ms > g.ms {
+		g.ms = ms
+		g.seq = binary.BigEndian.Uint16(b[6:8]) & 0x7ff
+	} else {
+		// same millisecond, or the clock went backwards.
+		g.seq++
+		if g.seq > 0xfff {
+			g.ms++
+			g.seq = 0
+		}
+	}
+	ms, seq := g.ms, g.seq
+	g.mu.Unlock()
+
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	b[6] = 0x70 | byte(seq>>8)
+	b[7] = byte(seq)
+	b[8] = 0x80 | (b[8] & 0x3f)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func (g *uuidV7) Valid(id string) bool {
+	return uuidV7Format.MatchString(id)
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid implements monotonic ulids, within the same millisecond the random part of
+// the previous id is incremented instead of drawing a new one.
+type ulid struct {
+	mu      sync.Mutex
+	ms      uint64
+	hi, lo  uint64 // the 80 bit random part, hi only uses 16 bits.
+	started bool
+}
+
+func (g *ulid) NewID() (string, error) {
+	var b [10]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if !g.started || ms > g.ms {
+		g.started = true
+		g.ms = ms
+		g.hi = uint64(binary.BigEndian.Uint16(b[:2]))
+		g.lo = binary.BigEndian.Uint64(b[2:])
+	} else {
+		g.lo++
+		if g.lo == 0 {
+			g.hi++
+			if g.hi > 0xffff {
+				g.hi = 0
+				g.ms++
+			}
+		}
+	}
+	ms, hi, lo := g.ms, g.hi, g.lo
+	g.mu.Unlock()
+
+	// the 128 bits are ms(48) | hi(16) | lo(64), encoded 5 bits at a time starting
+	// from the least significant ones.
+	top := ms<<16 | hi
+	var id [26]byte
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = crockford[lo&31]
+		lo = lo>>5 | top<<59
+		top >>= 5
+	}
+	return string(id[:]), nil
+}
+
+func (g *ulid) Valid(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !validCrockford(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func validCrockford(c byte) bool {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return true
+		}
+	}
+	return false
+}
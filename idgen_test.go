@@ -0,0 +1,92 @@
+package mrs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/unrolled/render"
+)
+
+func TestIDGenerators(t *testing.T) {
+	sample := []struct {
+		name    string
+		scheme  IDScheme
+		ordered bool
+	}{
+		{"uuid v4", UUIDv4, false},
+		{"uuid v7", UUIDv7, true},
+		{"ulid", ULID, true},
+	}
+	for _, v := range sample {
+		var ids []string
+		seen := make(map[string]bool)
+		for i := 0; i < 5000; i++ {
+			id, err := v.scheme.NewID()
+			if err != nil {
+				t.Fatalf("%s: %v", v.name, err)
+			}
+			if !v.scheme.Valid(id) {
+				t.Errorf("%s: expected %s to be valid", v.name, id)
+			}
+			if seen[id] {
+				t.Errorf("%s: duplicate id %s", v.name, id)
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		if v.ordered && !sort.StringsAreSorted(ids) {
+			t.Errorf("%s: expected ids to be sorted by creation time", v.name)
+		}
+	}
+	if ULID.Valid(strings.ToLower("01ARZ3NDEKTSV4RRFFQ69G5FAV")) || !ULID.Valid("01ARZ3NDEKTSV4RRFFQ69G5FAV") {
+		t.Error("Expected ulids to be upper case only")
+	}
+	if UUIDv7.Valid(pids[0]) {
+		t.Errorf("Expected %s not to be a uuid v7", pids[0])
+	}
+}
+
+func TestHandlers_Create(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.IDs = UUIDv7
+	handle.IDFormat = UUIDv7
+	defer cleanUp()
+
+	r, _ := http.NewRequest("POST", "/profile", strings.NewReader(`{"city":"mwanza","id":"../../escape"}`))
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handle.Create(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected %d actual %d", http.StatusCreated, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "escape") {
+		t.Errorf("Expected the client id to be ignored, got %s", w.Body.String())
+	}
+	p := new(Profile)
+	err := json.Unmarshal(w.Body.Bytes(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !UUIDv7.Valid(p.ID) {
+		t.Errorf("Expected %s to be a uuid v7", p.ID)
+	}
+	if p.City != "mwanza" {
+		t.Errorf("Expected mwanza actual %s", p.City)
+	}
+	id, err := ParseProfileIDFormat(p.ID, UUIDv7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := NewProfile(id).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.City != p.City {
+		t.Errorf("Expected %s actual %s", p.City, stored.City)
+	}
+}
@@ -0,0 +1,217 @@
+package mrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// The kinds of problems found by Check and CheckProfile.
+const (
+	// ProblemMissingData is a photo whose data is missing.
+	ProblemMissingData = "missing_data"
+
+	// ProblemOrphanData is data, of a photo or a rendition, without photo metadata or
+	// of an old crop revision.
+	ProblemOrphanData = "orphan_data"
+
+	// ProblemHashMismatch is a photo whose data doesn't match its hash.
+	ProblemHashMismatch = "hash_mismatch"
+
+	// ProblemUsage is a profile whose recorded usage doesn't match its photos, see
+	// RecountUsage.
+	ProblemUsage = "usage_mismatch"
+
+	// ProblemStaleIndex is an entry of the hash index which doesn't match a photo, see
+	// BackfillHashes.
+	ProblemStaleIndex = "stale_index"
+
+	// ProblemStaleQueue is an entry of the moderation queue whose photo is missing or
+	// not pending.
+	ProblemStaleQueue = "stale_queue"
+
+	// ProblemMissingPhoto is a profile referencing a photo which doesn't exist.
+	ProblemMissingPhoto = "missing_photo"
+)
+
+// Problem is an inconsistency in the databases.
+type Problem struct {
+	Kind string `json:"kind"`
+
+	// ID is the id of the photo or profile with the problem.
+	ID     string `json:"id"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (p Problem) String() string {
+	if p.Detail == "" {
+		return p.Kind + " " + p.ID
+	}
+	return p.Kind + " " + p.ID + ": " + p.Detail
+}
+
+// Check looks for inconsistencies between the photos, their data, the usage of the
+// profiles, the hash index and the moderation queue. Nothing is modified.
+func (p *PhotoManager) Check() ([]Problem, error) {
+	var problems []Problem
+	report := func(kind, id, format string, args ...interface{}) {
+		problems = append(problems, Problem{Kind: kind, ID: id, Detail: fmt.Sprintf(format, args...)})
+	}
+	err := view(p.db, func(tx *bolt.Tx) error {
+		photos := make(map[string]*Photo)
+		if meta := tx.Bucket([]byte(p.MetaBucket)); meta != nil {
+			err := meta.ForEach(func(k, v []byte) error {
+				photo := new(Photo)
+				if err := json.Unmarshal(v, photo); err != nil {
+					return err
+				}
+				photos[photo.ID] = photo
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		usage := make(map[string]*Usage)
+		content := tx.Bucket([]byte(p.DataBucket))
+		for id, photo := range photos {
+			u, ok := usage[photo.UploadedBy]
+			if !ok {
+				u = &Usage{ProfileID: photo.UploadedBy}
+				usage[photo.UploadedBy] = u
+			}
+			u.Photos++
+			u.Bytes += photo.storedSize()
+
+			var data []byte
+			if content != nil {
+				data = content.Get([]byte(id))
+			}
+			switch {
+			case data == nil:
+				report(ProblemMissingData, id, "")
+			case photo.Hash != "" && photoHash(data) != photo.Hash:
+				report(ProblemHashMismatch, id, "the data hashes to %s", photoHash(data))
+			}
+		}
+		if content != nil {
+			err := content.ForEach(func(k, v []byte) error {
+				key := string(k)
+				parts := strings.Split(key, "/")
+				photo := photos[parts[0]]
+				switch {
+				case photo == nil:
+					report(ProblemOrphanData, parts[0], "%s has no photo", key)
+				case len(parts) == 3:
+					rev, _ := strconv.Atoi(parts[2])
+					if photo.Crop == nil || photo.Crop.Revision != rev {
+						report(ProblemOrphanData, parts[0], "%s is not of the current crop", key)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if b := tx.Bucket([]byte(p.UsageBucket)); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				stored := new(Usage)
+				if err := json.Unmarshal(v, stored); err != nil {
+					return err
+				}
+				if _, ok := usage[stored.ProfileID]; !ok && (stored.Photos != 0 || stored.Bytes != 0) {
+					usage[stored.ProfileID] = &Usage{ProfileID: stored.ProfileID}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for id, u := range usage {
+			stored := &Usage{ProfileID: id}
+			if err := p.readUsage(tx, stored); err != nil {
+				return err
+			}
+			if stored.Photos != u.Photos || stored.Bytes != u.Bytes {
+				report(ProblemUsage, id, "recorded %d photos and %d bytes, found %d photos and %d bytes",
+					stored.Photos, stored.Bytes, u.Photos, u.Bytes)
+			}
+		}
+
+		if idx := tx.Bucket([]byte(p.HashBucket)); idx != nil {
+			err := idx.ForEach(func(k, v []byte) error {
+				photo := photos[string(v)]
+				if photo == nil || string(hashKey(photo.UploadedBy, photo.Hash)) != string(k) {
+					report(ProblemStaleIndex, string(v), "")
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if queue := tx.Bucket([]byte(p.ModerationBucket)); queue != nil {
+			err := queue.ForEach(func(k, v []byte) error {
+				photo := photos[string(k)]
+				switch {
+				case photo == nil:
+					report(ProblemStaleQueue, string(k), "the photo is missing")
+				case photo.Status != StatusPending:
+					report(ProblemStaleQueue, string(k), "the photo is %s", photo.Status)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Kind != problems[j].Kind {
+			return problems[i].Kind < problems[j].Kind
+		}
+		return problems[i].ID < problems[j].ID
+	})
+	return problems, nil
+}
+
+// CheckProfile looks for the photos referenced by the profile which don't exist, or
+// which belong to another profile.
+func (p *PhotoManager) CheckProfile(profile *Profile) ([]Problem, error) {
+	var problems []Problem
+	ids := profile.Photos
+	if profile.Picture != "" {
+		ids = append([]string{profile.Picture}, ids...)
+	}
+	for _, id := range ids {
+		photo, err := p.Get(id)
+		switch {
+		case errors.Is(err, ErrPhotoNotFound):
+			problems = append(problems, Problem{Kind: ProblemMissingPhoto, ID: profile.ID, Detail: id})
+		case err != nil:
+			return nil, err
+		case photo.UploadedBy != profile.ID:
+			problems = append(problems, Problem{
+				Kind:   ProblemMissingPhoto,
+				ID:     profile.ID,
+				Detail: fmt.Sprintf("%s belongs to %s", id, photo.UploadedBy),
+			})
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,105 @@
+package mrs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestPhotoManager_Check(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	problems, err := pm.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems got %v", problems)
+	}
+	first, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := saveTestPhoto(pm, pids[1], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = pm.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems got %v", problems)
+	}
+
+	err = update(pm.db, func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(pm.DataBucket))
+		if err := data.Delete([]byte(first.ID)); err != nil {
+			return err
+		}
+		if err := data.Put([]byte(second.ID), []byte("not the photo")); err != nil {
+			return err
+		}
+		if err := data.Put([]byte("orphan"), []byte("data")); err != nil {
+			return err
+		}
+		return pm.writeUsage(tx, &Usage{ProfileID: pids[1], Photos: 7})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = pm.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Problem{
+		{Kind: ProblemHashMismatch, ID: second.ID},
+		{Kind: ProblemMissingData, ID: first.ID},
+		{Kind: ProblemOrphanData, ID: "orphan"},
+		{Kind: ProblemUsage, ID: pids[1]},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("expected %v got %v", want, problems)
+	}
+	for i, v := range problems {
+		if v.Kind != want[i].Kind || v.ID != want[i].ID {
+			t.Errorf("expected %s %s got %v", want[i].Kind, want[i].ID, v)
+		}
+	}
+}
+
+func TestPhotoManager_CheckProfile(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProfile(MustParseProfileID(pids[0]))
+	p.Picture = photo.ID
+	problems, err := pm.CheckProfile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems got %v", problems)
+	}
+
+	p.Photos = []string{"missing"}
+	other := NewProfile(MustParseProfileID(pids[1]))
+	other.Picture = photo.ID
+	for _, profile := range []*Profile{p, other} {
+		problems, err = pm.CheckProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || problems[0].Kind != ProblemMissingPhoto || problems[0].ID != profile.ID {
+			t.Errorf("%s: expected a missing photo got %v", profile.ID, problems)
+		}
+	}
+}
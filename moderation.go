@@ -0,0 +1,268 @@
+package mrs
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+const defaultModerationBucket = "moderation"
+
+// RoleModerator is the role given to identities which review photos. Identities with
+// the admin role can review photos too.
+const RoleModerator = "moderator"
+
+var (
+	// ErrNotModerator is the message when the caller is not allowed to review photos.
+	ErrNotModerator = errors.New("sorry: only moderators can review photos")
+)
+
+// ModerationItem is a photo waiting in the moderation queue.
+type ModerationItem struct {
+	XMLName xml.Name `json:"-" xml:"item"`
+	Photo   *Photo   `json:"photo" xml:"-"`
+	PhotoID string   `json:"-" xml:"photo_id"`
+
+	// Reason tells why the photo is queued, it is the one of the first flag.
+	Reason string `json:"reason,omitempty" xml:"reason,omitempty"`
+
+	// Flags is how many times the photo was flagged while it was in the queue.
+	Flags    int       `json:"flags" xml:"flags"`
+	QueuedAt time.Time `json:"queued_at" xml:"queued_at"`
+}
+
+// queued is how items of the moderation queue are stored.
+type queued struct {
+	PhotoID  string    `json:"photo_id"`
+	Reason   string    `json:"reason,omitempty"`
+	Flags    int       `json:"flags"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// enqueue adds the photo to the moderation queue, or counts one more flag if it is
+// already there.
+func (p *PhotoManager) enqueue(tx *bolt.Tx, photo *Photo, reason string) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(p.ModerationBucket))
+	if err != nil {
+		return err
+	}
+	q := &queued{PhotoID: photo.ID, Reason: reason, QueuedAt: time.Now()}
+	if data := b.Get([]byte(photo.ID)); data != nil {
+		if err = json.Unmarshal(data, q); err != nil {
+			return err
+		}
+	}
+	q.Flags++
+	data, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(photo.ID), data)
+}
+
+// dequeue removes the photo from the moderation queue.
+func (p *PhotoManager) dequeue(tx *bolt.Tx, id string) error {
+	b := tx.Bucket([]byte(p.ModerationBucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(id))
+}
+
+// Flag puts the photo back in review, it is hidden to others than its owner until a
+// moderator approves it. Rejected photos stay rejected.
+func (p *PhotoManager) Flag(id, reason string) (*Photo, error) {
+	var photo *Photo
+	err := update(p.db, func(tx *bolt.Tx) error {
+		var err error
+		photo, err = p.flag(tx, id, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// flag is Flag within the transaction tx.
+func (p *PhotoManager) flag(tx *bolt.Tx, id, reason string) (*Photo, error) {
+	return p.editPhoto(tx, id, func(tx *bolt.Tx, photo *Photo) error {
+		if photo.Status == StatusRejected {
+			return nil
+		}
+		if photo.Status != StatusPending {
+			photo.Status = StatusPending
+			photo.StatusReason = reason
+			photo.UpdatedAt = time.Now()
+		}
+		return p.enqueue(tx, photo, reason)
+	})
+}
+
+// ModerationQueue returns the photos waiting for review, the oldest first.
+func (p *PhotoManager) ModerationQueue() ([]*ModerationItem, error) {
+	var items []*ModerationItem
+	err := view(p.db, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(p.ModerationBucket))
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if b == nil || meta == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			q := new(queued)
+			if err := json.Unmarshal(v, q); err != nil {
+				return err
+			}
+			m := meta.Get(k)
+			if m == nil {
+				// the photo was deleted while in the queue.
+				return nil
+			}
+			photo := new(Photo)
+			if err := json.Unmarshal(m, photo); err != nil {
+				return err
+			}
+			items = append(items, &ModerationItem{
+				Photo:    photo,
+				PhotoID:  photo.ID,
+				Reason:   q.Reason,
+				Flags:    q.Flags,
+				QueuedAt: q.QueuedAt,
+			})
+			return nil
+		})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].QueuedAt.Before(items[j].QueuedAt)
+	})
+	return items, nil
+}
+
+// hidden returns the ids among the given ones of the photos which are not approved.
+func (p *PhotoManager) hidden(ids []string) (map[string]bool, error) {
+	rst := make(map[string]bool)
+	err := view(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if meta == nil {
+			return nil
+		}
+		for _, id := range ids {
+			m := meta.Get([]byte(id))
+			if m == nil {
+				continue
+			}
+			photo := new(Photo)
+			if err := json.Unmarshal(m, photo); err != nil {
+				return err
+			}
+			if !photo.Approved() {
+				rst[id] = true
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return rst, nil
+}
+
+// moderator checks that the caller of the request can review photos, if not it
+// responds with an error and returns false. Unlike authorize, nothing is allowed when
+// the handlers have no Authenticator, since the caller can't be known to be a
+// moderator.
+func (h *Handlers) moderator(w http.ResponseWriter, r *http.Request, format string) (*Identity, bool) {
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return nil, false
+	}
+	if who == nil || !who.HasRole(RoleModerator) && !who.HasRole(RoleAdmin) {
+		h.renderError(w, format, newError(http.StatusForbidden, CodeForbidden, ErrNotModerator))
+		return nil, false
+	}
+	return who, true
+}
+
+// ModerationQueue sends the photos waiting for review as json or xml, the oldest
+// first. Only moderators can see the queue.
+func (h *Handlers) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "GET", "HEAD") {
+		return
+	}
+	if _, ok := h.moderator(w, r, format); !ok {
+		return
+	}
+	items, err := h.pm.ModerationQueue()
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if items == nil {
+		items = []*ModerationItem{}
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.render(w, format, http.StatusOK, items)
+}
+
+// ApprovePhoto approves the photo whose id is in the url path, it is then shown to
+// everyone allowed by the privacy settings of its profile. The photo is sent back as
+// json or xml.
+func (h *Handlers) ApprovePhoto(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, StatusApproved)
+}
+
+// RejectPhoto rejects the photo whose id is in the url path, it stays hidden to others
+// than its owner. The request body may be json with the reason, like
+// {"reason":"nudity"}. The photo is sent back as json or xml.
+func (h *Handlers) RejectPhoto(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, StatusRejected)
+}
+
+func (h *Handlers) review(w http.ResponseWriter, r *http.Request, status PhotoStatus) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	who, ok := h.moderator(w, r, format)
+	if !ok {
+		return
+	}
+	v := &Verdict{Status: status, By: who.UserID}
+	if status == StatusRejected {
+		form := struct {
+			Reason string `json:"reason"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&form)
+		if err != nil && err != io.EOF {
+			h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("bad review data")))
+			return
+		}
+		v.Reason = form.Reason
+	}
+	photo, err := h.pm.SetStatus(mux.Vars(r)["id"], v)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	h.render(w, format, http.StatusOK, photo)
+}
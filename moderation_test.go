@@ -0,0 +1,230 @@
+package mrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestPhotoManager_ModerationQueue(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Duplicates = DuplicateAllow
+	defer cleanUp()
+
+	items, err := pm.ModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected an empty queue got %d items", len(items))
+	}
+
+	pm.Scanner = verdict(StatusPending, "needs review")
+	pending, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Scanner = nil
+	approved, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = pm.Flag(approved.ID, "offensive"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err = pm.ModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items got %d", len(items))
+	}
+	if items[0].Photo.ID != pending.ID || items[0].Reason != "needs review" || items[0].Flags != 1 {
+		t.Errorf("unexpected first item %+v", items[0])
+	}
+	if items[1].Photo.ID != approved.ID || items[1].Reason != "offensive" || items[1].Flags != 2 {
+		t.Errorf("unexpected second item %+v", items[1])
+	}
+	if items[1].Photo.Approved() {
+		t.Error("expected the flagged photo to be hidden")
+	}
+
+	photo, err := pm.SetStatus(pending.ID, &Verdict{Status: StatusApproved, By: pids[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.ReviewedBy != pids[2] || photo.ReviewedAt == nil {
+		t.Errorf("expected the review to be recorded got %q %v", photo.ReviewedBy, photo.ReviewedAt)
+	}
+	if _, err = pm.SetStatus(approved.ID, &Verdict{Status: StatusRejected}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pm.Flag(approved.ID, "again"); err != nil {
+		t.Fatal(err)
+	}
+	items, err = pm.ModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected an empty queue got %d items", len(items))
+	}
+}
+
+func TestHandlers_Moderation(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.pm.Duplicates = DuplicateAllow
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/moderation", handle.ModerationQueue)
+	h.HandleFunc("/moderation/{id}/approve", handle.ApprovePhoto)
+	h.HandleFunc("/moderation/{id}/reject", handle.RejectPhoto)
+	h.HandleFunc("/profile/{id}", handle.Home)
+
+	defer cleanUp()
+	handle.pm.Scanner = verdict(StatusPending, "")
+	first, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.Picture = first.ID
+	profile.Photos = []string{first.ID, second.ID}
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, user, role, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-Role", role)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	home := func(user string) *ProfileView {
+		w := do("GET", fmt.Sprintf("/profile/%s", pids[0]), user, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d actual %d", http.StatusOK, w.Code)
+		}
+		v := new(ProfileView)
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := home(pids[1]); v.Picture != "" || len(v.Photos) != 0 || v.PicturePlaceholder != nil {
+		t.Errorf("expected the pending photos to be hidden got %+v", v)
+	}
+	if v := home(pids[0]); v.Picture != first.ID || len(v.Photos) != 2 {
+		t.Errorf("expected the owner to see the pending photos got %+v", v)
+	}
+
+	if w := do("GET", "/moderation", pids[1], "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	w := do("GET", "/moderation", pids[2], RoleModerator, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+	var items []*ModerationItem
+	if err = json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("expected 2 items got %d", len(items))
+	}
+
+	path := fmt.Sprintf("/moderation/%s/approve", first.ID)
+	if w := do("POST", path, pids[0], "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	if w := do("POST", path, pids[2], RoleModerator, ""); w.Code != http.StatusOK {
+		t.Errorf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+	path = fmt.Sprintf("/moderation/%s/reject", second.ID)
+	w = do("POST", path, pids[2], RoleAdmin, `{"reason":"nudity"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+	photo := new(Photo)
+	if err = json.Unmarshal(w.Body.Bytes(), photo); err != nil {
+		t.Fatal(err)
+	}
+	if photo.Status != StatusRejected || photo.StatusReason != "nudity" || photo.ReviewedBy != pids[2] {
+		t.Errorf("unexpected review %+v", photo)
+	}
+	if w := do("POST", "/moderation/nope/approve", pids[2], RoleModerator, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d actual %d", http.StatusNotFound, w.Code)
+	}
+
+	v := home(pids[1])
+	if v.Picture != first.ID || len(v.Photos) != 1 || v.Photos[0] != first.ID {
+		t.Errorf("expected only the approved photo got %+v", v)
+	}
+	if w := do("GET", "/moderation", pids[2], RoleModerator, ""); w.Body.String() != "[]" {
+		t.Errorf("expected an empty queue got %s", w.Body.String())
+	}
+}
+
+func TestHandlers_ModerationWithoutAuth(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/moderation", handle.ModerationQueue)
+	h.HandleFunc("/moderation/{id}/approve", handle.ApprovePhoto)
+	h.HandleFunc("/moderation/{id}/reject", handle.RejectPhoto)
+	h.HandleFunc("/reports", handle.Reports)
+
+	defer cleanUp()
+	handle.pm.Scanner = verdict(StatusPending, "")
+	photo, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct{ method, path string }{
+		{"GET", "/moderation"},
+		{"POST", fmt.Sprintf("/moderation/%s/approve", photo.ID)},
+		{"POST", fmt.Sprintf("/moderation/%s/reject", photo.ID)},
+		{"GET", "/reports"},
+	} {
+		r, _ := http.NewRequest(v.method, v.path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected %d actual %d", v.method, v.path, http.StatusForbidden, w.Code)
+		}
+	}
+	photo, err = handle.pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Status != StatusPending {
+		t.Errorf("expected the photo to stay pending got %s", photo.Status)
+	}
+}
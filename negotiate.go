@@ -0,0 +1,125 @@
+package mrs
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// media types the handlers can respond with.
+const (
+	mimeJSON = "application/json"
+	mimeHTML = "text/html"
+	mimeXML  = "application/xml"
+)
+
+// acceptRange is a single media range of the Accept header.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the Accept header into media ranges. A missing header is the
+// same as accepting anything.
+func parseAccept(header string) []acceptRange {
+	if strings.TrimSpace(header) == "" {
+		return []acceptRange{{"*", "*", 1}}
+	}
+	var rst []acceptRange
+	for _, v := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		if mt == "text/xml" {
+			mt = mimeXML
+		}
+		rng := acceptRange{q: 1}
+		if i := strings.Index(mt, "/"); i > 0 {
+			rng.typ, rng.subtype = mt[:i], mt[i+1:]
+		} else if mt == "*" {
+			rng.typ, rng.subtype = "*", "*"
+		} else {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			f, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			rng.q = f
+		}
+		rst = append(rst, rng)
+	}
+	return rst
+}
+
+// match returns the quality given to the media type mt, and how specific the
+// matching range was, 2 being an exact match and 0 a match by */*. A negative
+// specificity means no range matched.
+func (a acceptRange) match(mt string) (float64, int) {
+	i := strings.Index(mt, "/")
+	typ, subtype := mt[:i], mt[i+1:]
+	switch {
+	case a.typ == typ && a.subtype == subtype:
+		return a.q, 2
+	case a.typ == typ && a.subtype == "*":
+		return a.q, 1
+	case a.typ == "*" && a.subtype == "*":
+		return a.q, 0
+	}
+	return 0, -1
+}
+
+// negotiate picks from offers the media type which best matches the Accept header
+// of the request. The offers are in the order of preference of the handler, which
+// is used to break ties, so the first offer is what clients sending */* get.
+//
+// Older ajax clients only send the X-Requested-With header, so when the choice was
+// made by a wildcard, json is preferred for them if it is offered.
+//
+// An empty string is returned when none of the offers is acceptable, the handlers
+// respond with 406 Not Acceptable in that case.
+func negotiate(r *http.Request, offers ...string) string {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	best, bestQ, bestSpec := "", 0.0, -1
+	var jsonQ float64
+	for _, offer := range offers {
+		q, spec := 0.0, -1
+		for _, rng := range ranges {
+			rq, rspec := rng.match(offer)
+			if rspec > spec {
+				q, spec = rq, rspec
+			}
+		}
+		if offer == mimeJSON {
+			jsonQ = q
+		}
+		if spec < 0 || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && spec > bestSpec) {
+			best, bestQ, bestSpec = offer, q, spec
+		}
+	}
+	if best != "" && bestSpec == 0 && jsonQ >= bestQ && isXHR(r) {
+		return mimeJSON
+	}
+	return best
+}
+
+// isXHR reports whether the request was made with XMLHttpRequest by a library which
+// sets the X-Requested-With header.
+func isXHR(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// notAcceptable responds with 406 Not Acceptable, listing the media types which
+// could have been served. Like other errors, it is written as json or xml when the
+// client accepts one of them, and as plain text otherwise.
+func notAcceptable(w http.ResponseWriter, r *http.Request, offers ...string) {
+	err := fmt.Errorf("sorry: not acceptable, available types: %s", strings.Join(offers, ", "))
+	writeError(w, r, newError(http.StatusNotAcceptable, CodeNotAcceptable, err))
+}
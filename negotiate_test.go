@@ -0,0 +1,123 @@
+package mrs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestNegotiate(t *testing.T) {
+	sample := []struct {
+		accept string
+		xhr    bool
+		offers []string
+		expect string
+	}{
+		{"", false, []string{mimeHTML, mimeJSON}, mimeHTML},
+		{"", true, []string{mimeHTML, mimeJSON}, mimeJSON},
+		{"*/*", true, []string{mimeHTML, mimeJSON}, mimeJSON},
+		{"application/json", false, []string{mimeHTML, mimeJSON}, mimeJSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false, []string{mimeHTML, mimeJSON, mimeXML}, mimeHTML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true, []string{mimeHTML, mimeJSON, mimeXML}, mimeHTML},
+		{"application/json, text/javascript, */*; q=0.01", true, []string{mimeHTML, mimeJSON}, mimeJSON},
+		{"text/xml", false, []string{mimeJSON, mimeXML}, mimeXML},
+		{"application/*;q=0.5, application/xml", false, []string{mimeJSON, mimeXML}, mimeXML},
+		{"text/*", false, []string{mimeJSON, mimeXML}, ""},
+		{"application/json;q=0", false, []string{mimeJSON}, ""},
+		{"image/*", false, []string{"image/jpeg"}, "image/jpeg"},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if v.accept != "" {
+			r.Header.Set("Accept", v.accept)
+		}
+		if v.xhr {
+			r.Header.Set("X-Requested-With", "XMLHttpRequest")
+		}
+		got := negotiate(r, v.offers...)
+		if got != v.expect {
+			t.Errorf("%q xhr=%v: expected %q actual %q", v.accept, v.xhr, v.expect, got)
+		}
+	}
+}
+
+func TestHandlers_HomeNegotiation(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		accept string
+		code   int
+		ctype  string
+	}{
+		{"application/json", http.StatusOK, "application/json"},
+		{"application/xml", http.StatusOK, "text/xml"},
+		{"text/html", http.StatusOK, "text/html"},
+		{"image/png", http.StatusNotAcceptable, "text/plain"},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", pids[0]), nil)
+		r.Header.Set("Accept", v.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: expected %d actual %d", v.accept, v.code, w.Code)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), v.ctype) {
+			t.Errorf("%s: expected %s actual %s", v.accept, v.ctype, w.Header().Get("Content-Type"))
+		}
+		if v.code == http.StatusOK && !strings.Contains(w.Body.String(), pids[0]) {
+			t.Errorf("%s: expected %s to contain %s", v.accept, w.Body.String(), pids[0])
+		}
+	}
+}
+
+func TestHandlers_PhotoNegotiation(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	photo, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		accept, ctype string
+	}{
+		{"application/json", "application/json"},
+		{"application/xml", "text/xml"},
+		{"text/plain", "text/plain"},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/photo/%s", photo.ID), nil)
+		r.Header.Set("Accept", v.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotAcceptable {
+			t.Errorf("%s: expected %d actual %d", v.accept, http.StatusNotAcceptable, w.Code)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), v.ctype) {
+			t.Errorf("%s: expected %s actual %s", v.accept, v.ctype, w.Header().Get("Content-Type"))
+		}
+		if v.ctype != "text/plain" && !strings.Contains(w.Body.String(), CodeNotAcceptable) {
+			t.Errorf("%s: expected the %s code got %s", v.accept, CodeNotAcceptable, w.Body.String())
+		}
+	}
+}
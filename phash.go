@@ -0,0 +1,342 @@
+package mrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+)
+
+// maxBlockSamples is the number of pixels sampled along each side of the blocks which
+// are averaged when shrinking an image, so hashing doesn't get slower with the size
+// of the image.
+const maxBlockSamples = 16
+
+// defaultSimilarDistance is the distance used by SimilarPhotos when none is given, it
+// finds copies of a photo at another size or quality.
+const defaultSimilarDistance = 10
+
+// ErrNoFingerprint is the error of SimilarTo for photos saved before fingerprints were
+// computed, see BackfillHashes.
+var ErrNoFingerprint = errors.New("sorry: the photo has no fingerprint")
+
+// ImageHash is a 64 bit perceptual hash. Similar images have hashes which differ in a
+// few bits, see Distance. It is encoded as 16 hex digits in json.
+type ImageHash uint64
+
+// Distance returns the hamming distance between two hashes, that is the number of bits
+// which differ.
+func (h ImageHash) Distance(o ImageHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// String returns the hash as 16 hex digits.
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (h ImageHash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *ImageHash) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return err
+	}
+	*h = ImageHash(v)
+	return nil
+}
+
+// HashKind selects one of the hashes of a Fingerprint.
+type HashKind int
+
+// The kinds of perceptual hashes.
+const (
+	// AverageHash compares every pixel of an 8x8 thumbnail to the mean. It is the
+	// fastest, but gets fooled by changes of brightness.
+	AverageHash HashKind = iota
+
+	// DifferenceHash compares every pixel of a 9x8 thumbnail to the next one, which
+	// follows gradients rather than absolute values.
+	DifferenceHash
+
+	// PerceptualHash compares the low frequencies of the discrete cosine transform of
+	// a 32x32 thumbnail to their median. It is the most robust to resizing and
+	// recompression.
+	PerceptualHash
+)
+
+// hashKinds are the kinds of hashes by the name used in the kind parameter of
+// SimilarPhotos.
+var hashKinds = map[string]HashKind{
+	"average":    AverageHash,
+	"difference": DifferenceHash,
+	"perceptual": PerceptualHash,
+}
+
+// Fingerprint holds the perceptual hashes of a photo, they are computed when the photo
+// is uploaded. Unlike the sha-256 Hash, they match copies of the same photo saved at a
+// different size or quality.
+type Fingerprint struct {
+	AHash ImageHash `json:"ahash"`
+	DHash ImageHash `json:"dhash"`
+	PHash ImageHash `json:"phash"`
+}
+
+// NewFingerprint computes the perceptual hashes of img.
+func NewFingerprint(img image.Image) *Fingerprint {
+	return &Fingerprint{
+		AHash: averageHash(img),
+		DHash: differenceHash(img),
+		PHash: perceptualHash(img),
+	}
+}
+
+// Hash returns the hash of the given kind.
+func (f *Fingerprint) Hash(kind HashKind) ImageHash {
+	switch kind {
+	case AverageHash:
+		return f.AHash
+	case DifferenceHash:
+		return f.DHash
+	}
+	return f.PHash
+}
+
+// grayscale shrinks img to w x h, returning the luminance of the pixels row by row.
+// Every pixel is the average of the block of the source image it covers.
+func grayscale(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	out := make([]float64, w*h)
+	if b.Empty() {
+		return out
+	}
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		ys := (y1 - y0 + maxBlockSamples - 1) / maxBlockSamples
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			xs := (x1 - x0 + maxBlockSamples - 1) / maxBlockSamples
+			var sum float64
+			var n int
+			for sy := y0; sy < y1; sy += ys {
+				for sx := x0; sx < x1; sx += xs {
+					r, g, bl, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			out[y*w+x] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+func averageHash(img image.Image) ImageHash {
+	px := grayscale(img, 8, 8)
+	var mean float64
+	for _, v := range px {
+		mean += v
+	}
+	mean /= float64(len(px))
+	var h ImageHash
+	for i, v := range px {
+		if v > mean {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+func differenceHash(img image.Image) ImageHash {
+	px := grayscale(img, 9, 8)
+	var h ImageHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if px[y*9+x] > px[y*9+x+1] {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+func perceptualHash(img image.Image) ImageHash {
+	const n = 32
+	px := grayscale(img, n, n)
+
+	// separable dct-ii, only the 8x8 lowest frequencies are needed.
+	var cos [8][n]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += px[y*n+x] * cos[u][x]
+			}
+			rows[y][u] = s
+		}
+	}
+	var freq [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y][u] * cos[v][y]
+			}
+			freq[v*8+u] = s
+		}
+	}
+
+	// the first coefficient is the average brightness, it is left out of the median.
+	sorted := make([]float64, 63)
+	copy(sorted, freq[1:])
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var h ImageHash
+	for i, v := range freq {
+		if v > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// decodePhoto decodes the stored data of a photo.
+func decodePhoto(photo *Photo, data []byte) (image.Image, error) {
+	if photo.ContentType() == "image/png" {
+		return png.Decode(bytes.NewReader(data))
+	}
+	return jpeg.Decode(bytes.NewReader(data))
+}
+
+// SimilarPhoto is a photo found by Similar.
+type SimilarPhoto struct {
+	Photo    *Photo `json:"photo"`
+	Distance int    `json:"distance"`
+}
+
+// Similar returns the photos whose hash of the given kind is within maxDistance of the
+// one of fp, the closest first. Photos of all the profiles are searched, so it can be
+// used by moderators to find new uploads of banned photos, the UploadedBy field tells
+// whose photos they are.
+//
+// Photos without a fingerprint are skipped, see BackfillHashes.
+func (p *PhotoManager) Similar(fp *Fingerprint, kind HashKind, maxDistance int) ([]SimilarPhoto, error) {
+	want := fp.Hash(kind)
+	var rst []SimilarPhoto
+	err := view(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if meta == nil {
+			return nil
+		}
+		return meta.ForEach(func(k, v []byte) error {
+			photo := new(Photo)
+			if err := json.Unmarshal(v, photo); err != nil {
+				return err
+			}
+			if photo.Fingerprint == nil {
+				return nil
+			}
+			if d := photo.Fingerprint.Hash(kind).Distance(want); d <= maxDistance {
+				rst = append(rst, SimilarPhoto{Photo: photo, Distance: d})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rst, func(i, j int) bool {
+		return rst[i].Distance < rst[j].Distance
+	})
+	return rst, nil
+}
+
+// SimilarTo is like Similar, using the fingerprint of the photo with the given id. The
+// photo itself is part of the result, at distance zero.
+func (p *PhotoManager) SimilarTo(id string, kind HashKind, maxDistance int) ([]SimilarPhoto, error) {
+	photo, err := p.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if photo.Fingerprint == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoFingerprint, id)
+	}
+	return p.Similar(photo.Fingerprint, kind, maxDistance)
+}
+
+// SimilarPhotos sends the photos similar to the one whose id is in the url path as
+// json or xml, the closest first. The kind parameter is the hash compared, average,
+// difference or perceptual which is the default, and distance the number of bits in
+// which they may differ, 10 by default. Photos of all the profiles are searched, so
+// only moderators can use it.
+func (h *Handlers) SimilarPhotos(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "GET", "HEAD") {
+		return
+	}
+	if _, ok := h.moderator(w, r, format); !ok {
+		return
+	}
+	q := r.URL.Query()
+	kind := PerceptualHash
+	if v := q.Get("kind"); v != "" {
+		k, ok := hashKinds[v]
+		if !ok {
+			h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, fmt.Errorf("sorry: unknown hash kind %s", v)))
+			return
+		}
+		kind = k
+	}
+	distance := defaultSimilarDistance
+	if v := q.Get("distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 64 {
+			h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("sorry: the distance must be from 0 to 64")))
+			return
+		}
+		distance = d
+	}
+	rst, err := h.pm.SimilarTo(mux.Vars(r)["id"], kind, distance)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if rst == nil {
+		rst = []SimilarPhoto{}
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.render(w, format, http.StatusOK, rst)
+}
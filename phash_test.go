@@ -0,0 +1,185 @@
+package mrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// testImages returns me.jpg, a copy shrunk to half its size and saved at low quality,
+// and an unrelated image.
+func testImages(t *testing.T) (image.Image, image.Image, image.Image) {
+	f, err := os.Open("me.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	small := image.NewRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	for y := 0; y < b.Dy()/2; y++ {
+		for x := 0; x < b.Dx()/2; x++ {
+			small.Set(x, y, img.At(b.Min.X+2*x, b.Min.Y+2*y))
+		}
+	}
+	buf := new(bytes.Buffer)
+	err = jpeg.Encode(buf, small, &jpeg.Options{Quality: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := jpeg.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			other.SetGray(x, y, color.Gray{Y: 255 - uint8((r+g+bl)/3>>8)})
+		}
+	}
+	return img, copied, other
+}
+
+func TestFingerprint(t *testing.T) {
+	img, copied, other := testImages(t)
+	a, b, c := NewFingerprint(img), NewFingerprint(copied), NewFingerprint(other)
+	for _, kind := range []HashKind{AverageHash, DifferenceHash, PerceptualHash} {
+		if d := a.Hash(kind).Distance(b.Hash(kind)); d > 10 {
+			t.Errorf("%d: expected the resized copy to be close, distance %d", kind, d)
+		}
+		if d := a.Hash(kind).Distance(c.Hash(kind)); d < 20 {
+			t.Errorf("%d: expected the other image to be far, distance %d", kind, d)
+		}
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := new(Fingerprint)
+	err = json.Unmarshal(data, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *fp != *a {
+		t.Errorf("expected %+v got %+v", a, fp)
+	}
+}
+
+func TestPhotoManager_Similar(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Fingerprint == nil {
+		t.Fatal("expected the fingerprint to be computed on upload")
+	}
+	_, copied, other := testImages(t)
+
+	rst, err := pm.Similar(NewFingerprint(copied), PerceptualHash, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rst) != 1 || rst[0].Photo.ID != photo.ID {
+		t.Errorf("expected to find %s got %+v", photo.ID, rst)
+	}
+	rst, err = pm.Similar(NewFingerprint(other), PerceptualHash, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rst) != 0 {
+		t.Errorf("expected nothing got %+v", rst)
+	}
+	rst, err = pm.SimilarTo(photo.ID, DifferenceHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rst) != 1 || rst[0].Distance != 0 {
+		t.Errorf("expected the photo itself got %+v", rst)
+	}
+}
+
+func TestHandlers_SimilarPhotos(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.pm.Duplicates = DuplicateAllow
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}/similar", handle.SimilarPhotos)
+
+	defer cleanUp()
+	first, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := saveTestPhoto(handle.pm, pids[1], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(path, user, role string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-Role", role)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	path := "/photo/" + first.ID + "/similar"
+	if w := do(path, pids[0], ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	for _, query := range []string{"", "?kind=difference&distance=0"} {
+		w := do(path+query, pids[2], RoleModerator)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %d actual %d", query, http.StatusOK, w.Code)
+		}
+		var rst []SimilarPhoto
+		if err = json.Unmarshal(w.Body.Bytes(), &rst); err != nil {
+			t.Fatal(err)
+		}
+		// the same photo uploaded by another profile is found too.
+		if len(rst) != 2 || rst[0].Distance != 0 || rst[1].Distance != 0 {
+			t.Fatalf("%s: expected both photos got %+v", query, rst)
+		}
+		if ids := map[string]bool{rst[0].Photo.ID: true, rst[1].Photo.ID: true}; !ids[first.ID] || !ids[second.ID] {
+			t.Errorf("%s: expected %s and %s got %+v", query, first.ID, second.ID, rst)
+		}
+	}
+	sample := []struct {
+		path   string
+		status int
+	}{
+		{path + "?kind=fuzzy", http.StatusBadRequest},
+		{path + "?distance=-1", http.StatusBadRequest},
+		{path + "?distance=65", http.StatusBadRequest},
+		{path + "?distance=near", http.StatusBadRequest},
+		{"/photo/nope/similar", http.StatusNotFound},
+	}
+	for _, v := range sample {
+		if w := do(v.path, pids[2], RoleAdmin); w.Code != v.status {
+			t.Errorf("%s: expected %d actual %d", v.path, v.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package mrs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+)
+
+const (
+	// blurHashX and blurHashY are the number of components of the blurhash, 4x3 is
+	// what the blurhash authors recommend for photos.
+	blurHashX = 4
+	blurHashY = 3
+
+	// previewSize is the size of the longest side of the preview.
+	previewSize = 16
+
+	// blurHashSize is the size of the thumbnail the blurhash is computed from, the
+	// blurhash only keeps the lowest frequencies so more pixels don't help.
+	blurHashSize = 32
+)
+
+// Placeholder holds what frontends can show while a photo is loading.
+type Placeholder struct {
+	// BlurHash is the blurhash of the photo, see https://blurha.sh.
+	BlurHash string `json:"blurhash" xml:"blurhash"`
+
+	// Preview is a tiny, low quality version of the photo as a data uri, it can be
+	// used as is in the src of an img element.
+	Preview string `json:"preview" xml:"preview"`
+}
+
+// NewPlaceholder computes the placeholder of img.
+func NewPlaceholder(img image.Image) (*Placeholder, error) {
+	preview, err := previewURI(img)
+	if err != nil {
+		return nil, err
+	}
+	return &Placeholder{
+		BlurHash: BlurHash(shrink(img, blurHashSize, blurHashSize), blurHashX, blurHashY),
+		Preview:  preview,
+	}, nil
+}
+
+// previewURI encodes a copy of img, shrunk to previewSize, as a jpeg data uri.
+func previewURI(img image.Image) (string, error) {
+	b := img.Bounds()
+	w, h := previewSize, previewSize
+	switch {
+	case b.Dx() > b.Dy():
+		h = atLeastOne(previewSize * b.Dy() / b.Dx())
+	case b.Dy() > b.Dx():
+		w = atLeastOne(previewSize * b.Dx() / b.Dy())
+	}
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, shrink(img, w, h), &jpeg.Options{Quality: 50})
+	if err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// shrink returns a w x h copy of img, every pixel is the average of the block of the
+// source image it covers. Like grayscale, only a bounded number of pixels is sampled
+// in each block.
+func shrink(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	if b.Empty() {
+		return out
+	}
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := y0 + atLeastOne(b.Min.Y+(y+1)*b.Dy()/h-y0)
+		ys := (y1 - y0 + maxBlockSamples - 1) / maxBlockSamples
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := x0 + atLeastOne(b.Min.X+(x+1)*b.Dx()/w-x0)
+			xs := (x1 - x0 + maxBlockSamples - 1) / maxBlockSamples
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy += ys {
+				for sx := x0; sx < x1; sx += xs {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return out
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(sb *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+}
+
+func srgbToLinear(v uint32) float64 {
+	f := float64(v>>8) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// BlurHash returns the blurhash of img with x by y components, both between 1 and 9.
+// The whole image is read, so it should be shrunk first.
+func BlurHash(img image.Image, x, y int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	factors := make([][3]float64, 0, x*y)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for py := 0; py < h; py++ {
+				for px := 0; px < w; px++ {
+					basis := norm *
+						math.Cos(math.Pi*float64(i)*float64(px)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(py)/float64(h))
+					r, g, bl, _ := img.At(b.Min.X+px, b.Min.Y+py).RGBA()
+					f[0] += basis * srgbToLinear(r)
+					f[1] += basis * srgbToLinear(g)
+					f[2] += basis * srgbToLinear(bl)
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	sb := new(strings.Builder)
+	encode83(sb, (x-1)+(y-1)*9, 1)
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		var actual float64
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxValue = float64(quantised+1) / 166
+		encode83(sb, quantised, 1)
+	} else {
+		encode83(sb, 0, 1)
+	}
+	encode83(sb, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		q := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encode83(sb, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return sb.String()
+}
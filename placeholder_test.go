@@ -0,0 +1,100 @@
+package mrs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestBlurHash(t *testing.T) {
+	white := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			white.Set(x, y, color.White)
+		}
+	}
+	// the size flag of 4x3 components is L, and TSUA is the average color, white.
+	h := BlurHash(white, 4, 3)
+	if len(h) != 28 || h[0] != 'L' || h[2:6] != "TSUA" {
+		t.Errorf("unexpected blurhash %s", h)
+	}
+	if h := BlurHash(white, 1, 1); h != "00TSUA" {
+		t.Errorf("expected 00TSUA got %s", h)
+	}
+}
+
+func TestNewPlaceholder(t *testing.T) {
+	img, _, _ := testImages(t)
+	p, err := NewPlaceholder(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.BlurHash) != 4+2*blurHashX*blurHashY {
+		t.Errorf("unexpected blurhash %s", p.BlurHash)
+	}
+	prefix := "data:image/jpeg;base64,"
+	if !strings.HasPrefix(p.Preview, prefix) {
+		t.Fatalf("unexpected preview %s", p.Preview)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p.Preview, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width > previewSize || cfg.Height > previewSize || (cfg.Width != previewSize && cfg.Height != previewSize) {
+		t.Errorf("expected the longest side to be %d got %dx%d", previewSize, cfg.Width, cfg.Height)
+	}
+}
+
+func TestHandlers_HomePlaceholder(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+
+	defer cleanUp()
+	pic, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pic.Placeholder == nil {
+		t.Fatal("expected the placeholder to be computed on upload")
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.Picture = pic.ID
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", pids[0]), nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	v := new(ProfileView)
+	err = json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.PicturePlaceholder == nil || *v.PicturePlaceholder != *pic.Placeholder {
+		t.Errorf("expected the placeholder of the picture in %s", w.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+package mrs
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Visibility is who can see a profile field. The levels are ordered, a viewer who can
+// see the friends level can also see the members and public levels.
+type Visibility string
+
+// The visibility levels, from the widest audience to the narrowest.
+const (
+	Public  Visibility = "public"
+	Members Visibility = "members"
+	Friends Visibility = "friends"
+	Private Visibility = "private"
+)
+
+var (
+	// ErrInvalidPrivacy is the message when privacy settings refer to unknown fields
+	// or visibility levels.
+	ErrInvalidPrivacy = errors.New("sorry: invalid privacy settings")
+)
+
+// privateFields are the fields whose visibility can be set, keyed by their json name.
+var privateFields = map[string]bool{
+	"age":        true,
+	"birth_date": true,
+	"height":     true,
+	"weight":     true,
+	"hobies":     true,
+	"photos":     true,
+	"city":       true,
+	"country":    true,
+	"street":     true,
+}
+
+func (v Visibility) rank() int {
+	switch v {
+	case Public:
+		return 0
+	case Members:
+		return 1
+	case Friends:
+		return 2
+	}
+	// private and anything unknown, better safe than sorry.
+	return 3
+}
+
+// Allows reports whether a viewer at level v can see a field of visibility field.
+func (v Visibility) Allows(field Visibility) bool {
+	return field.rank() <= v.rank()
+}
+
+func (v Visibility) valid() bool {
+	switch v {
+	case Public, Members, Friends, Private:
+		return true
+	}
+	return false
+}
+
+// validPrivacy checks privacy settings, as sent by clients.
+func validPrivacy(settings map[string]Visibility) error {
+	for field, v := range settings {
+		if !privateFields[field] || !v.valid() {
+			return ErrInvalidPrivacy
+		}
+	}
+	return nil
+}
+
+// Validate checks the settings of the profile which are not enforced by its type,
+// the error is ErrInvalidPrivacy if the privacy settings are not valid.
+func (p *Profile) Validate() error {
+	return validPrivacy(p.Privacy)
+}
+
+// Visibility returns the visibility of the field with the given json name. Fields
+// without a setting are public.
+func (p *Profile) Visibility(field string) Visibility {
+	if v, ok := p.Privacy[field]; ok {
+		return v
+	}
+	return Public
+}
+
+// ProfileView is a profile as seen by a viewer. Fields which the viewer is not allowed
+// to see are nil, and are left out of the json and xml output. The privacy settings
+// are only seen by viewers at the private level.
+type ProfileView struct {
+	XMLName            xml.Name              `json:"-" xml:"profile"`
+	ID                 string                `json:"id" xml:"id"`
+	Version            int                   `json:"version" xml:"version"`
+	Owner              string                `json:"owner,omitempty" xml:"owner,omitempty"`
+	Picture            string                `json:"picture" xml:"picture"`
+	PicturePlaceholder *Placeholder          `json:"picture_placeholder,omitempty" xml:"picture_placeholder,omitempty"`
+	Age                *int                  `json:"age,omitempty" xml:"age,omitempty"`
+	BirthDate          *time.Time            `json:"birth_date,omitempty" xml:"birth_date,omitempty"`
+	Height             *int                  `json:"height,omitempty" xml:"height,omitempty"`
+	Weight             *int                  `json:"weight,omitempty" xml:"weight,omitempty"`
+	Hobies             []string              `json:"hobies,omitempty" xml:"hobies>hobby,omitempty"`
+	Photos             []string              `json:"photos,omitempty" xml:"photos>photo,omitempty"`
+	City               *string               `json:"city,omitempty" xml:"city,omitempty"`
+	Country            *string               `json:"country,omitempty" xml:"country,omitempty"`
+	Street             *string               `json:"street,omitempty" xml:"street,omitempty"`
+	Privacy            map[string]Visibility `json:"privacy,omitempty" xml:"-"`
+	CreatedAt          time.Time             `json:"created_at" xml:"created_at"`
+	UpdatedAt          time.Time             `json:"update_at" xml:"update_at"`
+}
+
+// View projects the profile for a viewer at the given level.
+func (p *Profile) View(level Visibility) *ProfileView {
+	v := &ProfileView{
+		ID:        p.ID,
+		Version:   p.Version,
+		Owner:     p.Owner,
+		Picture:   p.Picture,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+	}
+	show := func(field string) bool {
+		return level.Allows(p.Visibility(field))
+	}
+	if show("age") {
+		v.Age = &p.Age
+	}
+	if show("birth_date") {
+		v.BirthDate = &p.BirthDate
+	}
+	if show("height") {
+		v.Height = &p.Height
+	}
+	if show("weight") {
+		v.Weight = &p.Weight
+	}
+	if show("hobies") {
+		v.Hobies = p.Hobies
+	}
+	if show("photos") {
+		v.Photos = p.Photos
+	}
+	if show("city") {
+		v.City = &p.City
+	}
+	if show("country") {
+		v.Country = &p.Country
+	}
+	if show("street") {
+		v.Street = &p.Street
+	}
+	if level == Private {
+		v.Privacy = p.Privacy
+	}
+	return v
+}
+
+// Relations resolves the relationship between a viewer and the owner of a profile, as
+// the highest visibility level the viewer can see. The viewer is nil for anonymous
+// requests.
+type Relations interface {
+	Relationship(viewer *Identity, p *Profile) Visibility
+}
+
+// RelationsFunc is an adapter which allows ordinary functions to be used as Relations.
+type RelationsFunc func(viewer *Identity, p *Profile) Visibility
+
+// Relationship calls f(viewer, p).
+func (f RelationsFunc) Relationship(viewer *Identity, p *Profile) Visibility {
+	return f(viewer, p)
+}
+
+// DefaultRelations knows nothing about friendship. Anonymous viewers see public
+// fields, signed in viewers see fields for members, and the owner and admins see
+// everything.
+var DefaultRelations Relations = RelationsFunc(func(viewer *Identity, p *Profile) Visibility {
+	switch {
+	case viewer == nil:
+		return Public
+	case OwnerPolicy.CanModify(viewer, p):
+		return Private
+	}
+	return Members
+})
+
+// relationship resolves the relationship between the caller of the request and the
+// owner of the profile.
+func (h *Handlers) relationship(r *http.Request, p *Profile) Visibility {
+	// bad credentials are not an error when viewing, the caller is just anonymous.
+	who, _ := h.identity(r)
+	relations := h.Relations
+	if relations == nil {
+		relations = DefaultRelations
+	}
+	return relations.Relationship(who, p)
+}
+
+// view projects p for the caller of the request. The placeholder of the profile
+// picture is added, if it can be found. Photos which are not approved are left out,
+// unless the caller is the owner.
+func (h *Handlers) view(r *http.Request, p *Profile) *ProfileView {
+	level := h.relationship(r, p)
+	v := p.View(level)
+	if level != Private && (v.Picture != "" || len(v.Photos) > 0) {
+		if hidden, err := h.pm.hidden(append([]string{v.Picture}, v.Photos...)); err == nil {
+			if hidden[v.Picture] {
+				v.Picture = ""
+			}
+			var photos []string
+			for _, id := range v.Photos {
+				if !hidden[id] {
+					photos = append(photos, id)
+				}
+			}
+			v.Photos = photos
+		}
+	}
+	if v.Picture != "" {
+		if pic, err := h.pm.Get(v.Picture); err == nil {
+			v.PicturePlaceholder = pic.Placeholder
+		}
+	}
+	return v
+}
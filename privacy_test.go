@@ -0,0 +1,147 @@
+package mrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestProfile_View(t *testing.T) {
+	p := &Profile{
+		ID:     pids[0],
+		City:   "mwanza",
+		Street: "uhuru",
+		Weight: 70,
+		Privacy: map[string]Visibility{
+			"street": Friends,
+			"weight": Private,
+		},
+	}
+	sample := []struct {
+		level          Visibility
+		street, weight bool
+	}{
+		{Public, false, false},
+		{Members, false, false},
+		{Friends, true, false},
+		{Private, true, true},
+	}
+	for _, v := range sample {
+		view := p.View(v.level)
+		if view.City == nil || *view.City != p.City {
+			t.Errorf("%s: expected the city to be public", v.level)
+		}
+		if (view.Street != nil) != v.street {
+			t.Errorf("%s: expected street visible %v", v.level, v.street)
+		}
+		if (view.Weight != nil) != v.weight {
+			t.Errorf("%s: expected weight visible %v", v.level, v.weight)
+		}
+		if (view.Privacy != nil) != (v.level == Private) {
+			t.Errorf("%s: expected the privacy settings only for the owner", v.level)
+		}
+	}
+
+	data, err := json.Marshal(p.View(Public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"street", "weight", "privacy"} {
+		if strings.Contains(string(data), `"`+field+`"`) {
+			t.Errorf("expected %s to be left out of %s", field, data)
+		}
+	}
+}
+
+func TestValidPrivacy(t *testing.T) {
+	sample := []struct {
+		settings map[string]Visibility
+		valid    bool
+	}{
+		{nil, true},
+		{map[string]Visibility{"street": Friends, "age": Public}, true},
+		{map[string]Visibility{"street": "everyone"}, false},
+		{map[string]Visibility{"id": Private}, false},
+	}
+	for _, v := range sample {
+		err := validPrivacy(v.settings)
+		if (err == nil) != v.valid {
+			t.Errorf("%v: expected valid %v got %v", v.settings, v.valid, err)
+		}
+	}
+}
+
+func TestHandlers_HomePrivacy(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.Relations = RelationsFunc(func(viewer *Identity, p *Profile) Visibility {
+		if viewer != nil && viewer.UserID == pids[2] {
+			return Friends
+		}
+		return DefaultRelations.Relationship(viewer, p)
+	})
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}", handle.Home)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.City = "mwanza"
+	profile.Street = "uhuru"
+	profile.Weight = 70
+	profile.Privacy = map[string]Visibility{
+		"city":   Members,
+		"street": Friends,
+		"weight": Private,
+	}
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []struct {
+		user    string
+		visible []string
+		hidden  []string
+	}{
+		{"", nil, []string{"city", "street", "weight"}},
+		{pids[1], []string{"city"}, []string{"street", "weight"}},
+		{pids[2], []string{"city", "street"}, []string{"weight"}},
+		{pids[0], []string{"city", "street", "weight", "privacy"}, nil},
+	}
+	etags := make(map[string]bool)
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/%s", pids[0]), nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-User", v.user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: expected %d actual %d", v.user, http.StatusOK, w.Code)
+			continue
+		}
+		body := w.Body.String()
+		for _, field := range v.visible {
+			if !strings.Contains(body, `"`+field+`"`) {
+				t.Errorf("%q: expected %s in %s", v.user, field, body)
+			}
+		}
+		for _, field := range v.hidden {
+			if strings.Contains(body, `"`+field+`"`) {
+				t.Errorf("%q: expected no %s in %s", v.user, field, body)
+			}
+		}
+		etags[w.Header().Get("ETag")] = true
+	}
+	if len(etags) != len(sample) {
+		t.Errorf("expected a different ETag per view, got %v", etags)
+	}
+}
@@ -5,27 +5,49 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image/jpeg"
-	"image/png"
+	"image"
+	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/gernest/nutz"
-	u "github.com/nu7hatch/gouuid"
 )
 
-const defaultMaxMemory = 32 << 20 //32MB
+const (
+	defaultMaxMemory     = 32 << 20  //32MB
+	defaultMaxUploadSize = 128 << 20 //128MB
+)
+
+var (
+	// ErrProfileModified is returned when a conditional update is attempted against
+	// a stale version of the profile.
+	ErrProfileModified = errors.New("sorry: the profile has been modified by someone else")
+
+	// ErrUnsupportedFile is returned when the uploaded file is not a supported image.
+	ErrUnsupportedFile = errors.New("mrs: file not supported")
+
+	// ErrInvalidImage is returned when the uploaded image can't be decoded.
+	ErrInvalidImage = errors.New("mrs: invalid image")
+)
 
 // Profile contains  some basic fields for a user profile
 //
+// The Version field is incremented every time the profile is saved, it is used
+// to detect concurrent modifications, see UpdateIf.
+//
 // TODO (gernest): add validation.
 // TODO (gernest): add a faster serialization implementation
 type Profile struct {
 	store     nutz.Storage `json:"-"`
+	path      string       `json:"-"`
 	ID        string       `json:"id"`
+	Version   int          `json:"version"`
+	Owner     string       `json:"owner,omitempty"`
 	Picture   string       `json:"picture"`
 	Age       int          `json:"age"`
 	BirthDate time.Time    `json:"birth_date"`
@@ -38,26 +60,107 @@ type Profile struct {
 	Street    string       `json:"street"`
 	CreatedAt time.Time    `json:"created_at"`
 	UpdatedAt time.Time    `json:"update_at"`
+
+	// Privacy holds the visibility of the fields, keyed by their json name. Fields
+	// which are not in the map are public.
+	Privacy map[string]Visibility `json:"privacy,omitempty"`
 }
 
 // Photo stores metadata of uploaded file. Photos are kept in two version, the
 // metadata part and the actual data part. They both reside in the same database
-// but in different buckets, the two versions shares the same ID. The ID field must
-// be a uuid v4 string.
+// but in different buckets, the two versions shares the same ID. The ID field is
+// generated by the IDs generator of the PhotoManager.
 type Photo struct {
 	ID         string    `json:"id"`
 	Type       string    `json:"type"`
 	Size       int       `json:"size"`
+	Hash       string    `json:"hash,omitempty"`
 	UploadedBy string    `json:"uploaded_by"`
 	UploadedAt time.Time `json:"uploaded_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Fingerprint holds the perceptual hashes, see Similar.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// Placeholder is shown by frontends while the photo is loading.
+	Placeholder *Placeholder `json:"placeholder,omitempty"`
+
+	// Crop is how the square renditions are cropped, it is nil until the photo is
+	// cropped. RenditionsSize is the size of the renditions, it counts toward the
+	// usage of the profile along with Size.
+	Crop           *Crop `json:"crop,omitempty"`
+	RenditionsSize int64 `json:"renditions_size,omitempty"`
+
+	// Status is where the photo is in the review of uploads, see Scanner.
+	Status       PhotoStatus `json:"status,omitempty"`
+	StatusReason string      `json:"status_reason,omitempty"`
+
+	// ReviewedBy is the moderator who last reviewed the photo, and ReviewedAt when.
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// ContentType returns the mime type of the photo data.
+func (p *Photo) ContentType() string {
+	switch p.Type {
+	case "png", "PNG":
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// format returns the format of the photo data, formatPNG or formatJPEG.
+func (p *Photo) format() string {
+	if p.ContentType() == "image/png" {
+		return formatPNG
+	}
+	return formatJPEG
 }
 
 // PhotoManager helps in photo management
 type PhotoManager struct {
 	store      nutz.Storage
+	db         string
 	MetaBucket string
 	DataBucket string
+
+	// IDs generates the ids of new photos, it defaults to UUIDv4. Use UUIDv7 or ULID
+	// to have photos sorted by upload time in the buckets.
+	IDs IDGenerator
+
+	// UsageBucket is where the storage used by each profile is tracked.
+	UsageBucket string
+
+	// Quota limits the photos every profile can store, there is no limit by default.
+	Quota Quota
+
+	// HashBucket is where the photos of each profile are indexed by the hash of their
+	// data, to find duplicates.
+	HashBucket string
+
+	// Duplicates decides what happens to photos which a profile already has, the
+	// default is to reuse the stored photo.
+	Duplicates DuplicatePolicy
+
+	// AvatarSizes are the sizes of the square renditions generated by Crop.
+	AvatarSizes []int
+
+	// Encoding is how photos and their renditions are encoded.
+	Encoding EncodingPolicy
+
+	// Limits bound the dimensions of uploaded images, see DecodeLimits.
+	Limits DecodeLimits
+
+	// Scanner scans uploads before they are saved, there is none by default.
+	Scanner Scanner
+
+	// ModerationBucket is where the photos waiting for review are queued.
+	ModerationBucket string
+
+	// ReportBucket is where the reports about profiles and photos are stored, and
+	// Escalation decides when they are escalated.
+	ReportBucket string
+	Escalation   ReportPolicy
 }
 
 // FileUpload holds data about the uploaded file
@@ -73,10 +176,14 @@ type FileUpload struct {
 // I think by doing this it waill make management of profiles easy, and by the way,
 // the profile data is inside the userID bucket, meaning we can store other info that
 // are related to the profile in the same database( which is what I'm trying to do).
-func NewProfile(userID string) *Profile {
+//
+// The userID is a ProfileID, which can only be obtained by parsing, see ParseProfileID.
+// This guarantees that the database path always stays inside the db directory.
+func NewProfile(userID ProfileID) *Profile {
 	p := new(Profile)
-	p.store = nutz.NewStorage("db/"+userID+".db", 0600, nil)
-	p.ID = userID
+	p.path = userID.dbPath()
+	p.store = nutz.NewStorage(p.path, 0600, nil)
+	p.ID = userID.String()
 
 	// The db folder must exist, so that we can be able to create our database there
 	// TODO (gernest): Move this elsewhere, but meanwhile I can't think of a beeter
@@ -92,6 +199,7 @@ func NewProfile(userID string) *Profile {
 // name is in the form of db/{userID}.db where ueserID is a uuid v4 string.
 func (p *Profile) Create() error {
 	p.CreatedAt = time.Now()
+	p.Version = 1
 	data, err := json.Marshal(p)
 	if err != nil {
 		return err
@@ -103,12 +211,23 @@ func (p *Profile) Create() error {
 // Get retrieves a given profile object from the database and Unmarshall it to the
 // caller. The caller object must have the ID field set. Note that, its wise to call
 // this method on new Profile objects created by NewProfile.
+//
+// If the profile does not exist, the error is ErrProfileNotFound.
 func (p *Profile) Get() (*Profile, error) {
-	s := p.store.Get(p.ID, p.ID)
-	if s.Error != nil {
-		return nil, s.Error
+	err := view(p.path, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(p.ID))
+		if b == nil {
+			return ErrProfileNotFound
+		}
+		data := b.Get([]byte(p.ID))
+		if data == nil {
+			return ErrProfileNotFound
+		}
+		return json.Unmarshal(data, p)
+	})
+	if os.IsNotExist(err) {
+		err = ErrProfileNotFound
 	}
-	err := json.Unmarshal(s.Data, p)
 	if err != nil {
 		return nil, err
 	}
@@ -121,13 +240,78 @@ func (p *Profile) Get() (*Profile, error) {
 //
 // If the  Profile.ID is not found in the the database, an error is returned.
 func (p *Profile) Update() error {
-	p.UpdatedAt = time.Now()
-	data, err := json.Marshal(p)
+	return p.save(nil)
+}
+
+// UpdateIf is like Update, but the profile is only saved if the version stored in
+// the database is still version. The comparison and the write happen in the same
+// bolt transaction, so two concurrent callers holding the same version can't
+// overwrite each other, the loser gets ErrProfileModified.
+func (p *Profile) UpdateIf(version int) error {
+	return p.save(func(stored *Profile) error {
+		if stored.Version != version {
+			return ErrProfileModified
+		}
+		return nil
+	})
+}
+
+// save writes the profile inside a single transaction, bumping the version. The
+// check function if not nil is called with the stored profile before writing,
+// returning an error from it aborts the transaction.
+func (p *Profile) save(check func(stored *Profile) error) error {
+	version, updatedAt := p.Version, p.UpdatedAt
+	err := update(p.path, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(p.ID))
+		if b == nil {
+			return ErrProfileNotFound
+		}
+		data := b.Get([]byte(p.ID))
+		if data == nil {
+			return ErrProfileNotFound
+		}
+		stored := new(Profile)
+		err := json.Unmarshal(data, stored)
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			if err = check(stored); err != nil {
+				return err
+			}
+		}
+		p.Version = stored.Version + 1
+		p.UpdatedAt = time.Now()
+		data, err = json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(p.ID), data)
+	})
 	if err != nil {
-		return err
+		p.Version, p.UpdatedAt = version, updatedAt
+	}
+	return err
+}
+
+// edit copies the fields which the profile owner is allowed to change from o. The
+// ID, version, pictures and timestamps are managed by this package and are left
+// untouched.
+func (p *Profile) edit(o *Profile) {
+	p.Age = o.Age
+	p.BirthDate = o.BirthDate
+	p.Height = o.Height
+	p.Weight = o.Weight
+	p.Hobies = o.Hobies
+	p.City = o.City
+	p.Country = o.Country
+	p.Street = o.Street
+
+	// privacy settings are only replaced when given, so clients which don't know
+	// about them can't make private fields public by accident.
+	if o.Privacy != nil {
+		p.Privacy = o.Privacy
 	}
-	r := p.store.Update(p.ID, p.ID, data)
-	return r.Error
 }
 
 // Delete removes a given profile object from the database.
@@ -143,20 +327,212 @@ func (p *Profile) Deleta() error {
 // The db is the database name to be used.
 func NewPhotoManager(db, meta, data string) *PhotoManager {
 	return &PhotoManager{
-		store:      nutz.NewStorage(db, 0600, nil),
-		MetaBucket: meta,
-		DataBucket: data,
+		store:       nutz.NewStorage(db, 0600, nil),
+		db:          db,
+		MetaBucket:  meta,
+		DataBucket:  data,
+		IDs:         UUIDv4,
+		UsageBucket: defaultUsageBucket,
+		HashBucket:  defaultHashBucket,
+		AvatarSizes: defaultAvatarSizes,
+		Encoding:    DefaultEncodingPolicy,
+		Limits:      DefaultDecodeLimits,
+
+		ModerationBucket: defaultModerationBucket,
+		ReportBucket:     defaultReportBucket,
+		Escalation:       DefaultReportPolicy,
+	}
+}
+
+// Get retrieves the metadata of the photo with the given id. If there is no such
+// photo, the error is ErrPhotoNotFound.
+func (p *PhotoManager) Get(id string) (*Photo, error) {
+	photo := new(Photo)
+	err := p.get(p.MetaBucket, id, func(data []byte) error {
+		return json.Unmarshal(data, photo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// Photos returns the photos of the profile, or of all the profiles if profileID is
+// empty, in the order of their ids.
+func (p *PhotoManager) Photos(profileID string) ([]*Photo, error) {
+	var photos []*Photo
+	err := view(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if meta == nil {
+			return nil
+		}
+		return meta.ForEach(func(k, v []byte) error {
+			photo := new(Photo)
+			if err := json.Unmarshal(v, photo); err != nil {
+				return err
+			}
+			if profileID == "" || photo.UploadedBy == profileID {
+				photos = append(photos, photo)
+			}
+			return nil
+		})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
+	return photos, nil
+}
+
+// Delete removes the photo with the given id along with its renditions, and updates
+// the usage of its profile. It is removed from the hash index and the moderation queue
+// too, but profiles referencing the photo are left alone. The deleted photo is
+// returned.
+func (p *PhotoManager) Delete(id string) (*Photo, error) {
+	photo := new(Photo)
+	err := update(p.db, func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(p.MetaBucket))
+		if meta == nil {
+			return ErrPhotoNotFound
+		}
+		m := meta.Get([]byte(id))
+		if m == nil {
+			return ErrPhotoNotFound
+		}
+		if err := json.Unmarshal(m, photo); err != nil {
+			return err
+		}
+		if err := meta.Delete([]byte(id)); err != nil {
+			return err
+		}
+		if content := tx.Bucket([]byte(p.DataBucket)); content != nil {
+			if err := content.Delete([]byte(id)); err != nil {
+				return err
+			}
+			if err := deleteRenditions(content, id); err != nil {
+				return err
+			}
+		}
+		if idx := tx.Bucket([]byte(p.HashBucket)); idx != nil && photo.Hash != "" {
+			key := hashKey(photo.UploadedBy, photo.Hash)
+			if string(idx.Get(key)) == id {
+				if err := idx.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := p.dequeue(tx, id); err != nil {
+			return err
+		}
+		u := &Usage{ProfileID: photo.UploadedBy}
+		if err := p.readUsage(tx, u); err != nil {
+			return err
+		}
+		if u.Photos > 0 {
+			u.Photos--
+		}
+		if u.Bytes -= photo.storedSize(); u.Bytes < 0 {
+			u.Bytes = 0
+		}
+		return p.writeUsage(tx, u)
+	})
+	if os.IsNotExist(err) {
+		err = ErrPhotoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// GetData retrieves the encoded image of the photo with the given id.
+func (p *PhotoManager) GetData(id string) ([]byte, error) {
+	var rst []byte
+	err := p.get(p.DataBucket, id, func(data []byte) error {
+		rst = append(rst, data...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rst, nil
+}
+
+// ReadData calls fn with a reader over the encoded image of the photo with the given
+// id. The reader is backed by the memory map of the database, this way large photos
+// are not loaded into memory, but it is only valid until fn returns.
+func (p *PhotoManager) ReadData(id string, fn func(io.ReadSeeker) error) error {
+	return p.get(p.DataBucket, id, func(data []byte) error {
+		return fn(bytes.NewReader(data))
+	})
+}
+
+// get calls fn with the value of key in bucket, the value is only valid until fn
+// returns.
+func (p *PhotoManager) get(bucket, key string, fn func([]byte) error) error {
+	err := view(p.db, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrPhotoNotFound
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return ErrPhotoNotFound
+		}
+		return fn(data)
+	})
+	if os.IsNotExist(err) {
+		return ErrPhotoNotFound
+	}
+	return err
+}
+
+// updatePhoto calls fn with the metadata of the photo with the given id, and saves
+// the changes made by fn in the same transaction. The updated photo is returned.
+func (p *PhotoManager) updatePhoto(id string, fn func(*bolt.Tx, *Photo) error) (*Photo, error) {
+	var photo *Photo
+	err := update(p.db, func(tx *bolt.Tx) error {
+		var err error
+		photo, err = p.editPhoto(tx, id, fn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// editPhoto is updatePhoto within the transaction tx.
+func (p *PhotoManager) editPhoto(tx *bolt.Tx, id string, fn func(*bolt.Tx, *Photo) error) (*Photo, error) {
+	meta := tx.Bucket([]byte(p.MetaBucket))
+	if meta == nil {
+		return nil, ErrPhotoNotFound
+	}
+	m := meta.Get([]byte(id))
+	if m == nil {
+		return nil, ErrPhotoNotFound
+	}
+	photo := new(Photo)
+	if err := json.Unmarshal(m, photo); err != nil {
+		return nil, err
+	}
+	if err := fn(tx, photo); err != nil {
+		return nil, err
+	}
+	m, err := json.Marshal(photo)
+	if err != nil {
+		return nil, err
+	}
+	return photo, meta.Put([]byte(id), m)
 }
 
 // NewPhoto returns a new Photo object, given a profileID. The returned Photo object
-// has a unique uuid v4 and the Photo.ProfileID set to profileID.
-func (p *PhotoManager) NewPhoto(profileID string) *Photo {
-	uuid, err := u.NewV4()
+// has a unique id generated by p.IDs and the Photo.UploadedBy set to profileID.
+func (p *PhotoManager) NewPhoto(profileID string) (*Photo, error) {
+	id, err := p.IDs.NewID()
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
 	}
-	return &Photo{ID: uuid.String(), UploadedBy: profileID}
+	return &Photo{ID: id, UploadedBy: profileID}, nil
 }
 
 // GetUploadedFiles extracts uploaded files from a given request. The filedName argument
@@ -178,12 +554,14 @@ func (p *PhotoManager) GetUploadFiles(r *http.Request, fieldName string) ([]*Fil
 		var rst []*FileUpload
 		var ferr error
 		for _, v := range up {
-			f, ferr := v.Open()
-			if ferr != nil {
+			f, err := v.Open()
+			if err != nil {
+				ferr = err
 				continue
 			}
-			file, ferr := p.getFileUpload(f)
-			if ferr != nil {
+			file, err := p.getFileUpload(f)
+			if err != nil {
+				ferr = err
 				continue
 			}
 			rst = append(rst, file)
@@ -242,23 +620,26 @@ func (p *PhotoManager) getFileExt(file multipart.File) (string, error) {
 	case "image/png":
 		return "png", nil
 	default:
-		return "", fmt.Errorf("file %s not supported", f)
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFile, f)
 	}
 
 }
 
-// SaveMultiple stores multiple uploaded files.
+// SaveMultiple is like SaveSingle but for several files. The files are saved in a
+// single transaction, so if any of them fails or they don't fit in the quota of the
+// profile, none is saved.
 func (p *PhotoManager) SaveMultiple(files []*FileUpload, profileID string) ([]*Photo, error) {
-	var savedFiles []*Photo
+	var photos []*Photo
+	var data [][]byte
 	for _, v := range files {
-		id, err := p.SaveSingle(v, profileID)
+		photo, b, err := p.newPhotoData(v, profileID)
 		if err != nil {
-			return savedFiles, err
+			return nil, err
 		}
-		savedFiles = append(savedFiles, id)
-
+		photos = append(photos, photo)
+		data = append(data, b)
 	}
-	return savedFiles, nil
+	return p.save(profileID, photos, data)
 }
 
 // SaveSingle stores a given file into the database. The file is broken ito two parts
@@ -271,59 +652,147 @@ func (p *PhotoManager) SaveMultiple(files []*FileUpload, profileID string) ([]*P
 // will go into the  the DataBucket attribute.
 //
 // All the two parts shares the same Key, which is generated with the NewPhoto method.
+//
+// The usage of the profile is updated in the same transaction, if the photo doesn't
+// fit in the quota the error is ErrQuotaExceeded. When the profile already has the
+// same photo, what happens depends on the Duplicates policy, by default the stored
+// photo is returned and nothing new is saved.
+//
+// The file is scanned first when there is a Scanner, rejected files are not saved and
+// the error is ErrPhotoRejected.
 func (p *PhotoManager) SaveSingle(file *FileUpload, profileID string) (*Photo, error) {
-	photo := p.NewPhoto(profileID)
-	photo.Type = file.Ext
-	data, err := p.encodePhoto(file)
+	photo, data, err := p.newPhotoData(file, profileID)
 	if err != nil {
 		return nil, err
 	}
-	photo.Size = len(data)
-	photo.UploadedAt = time.Now()
-	photo.UpdatedAt = time.Now()
-
-	meta, err := json.Marshal(photo)
+	photos, err := p.save(profileID, []*Photo{photo}, [][]byte{data})
 	if err != nil {
 		return nil, err
 	}
+	return photos[0], nil
+}
 
-	s := p.store.Create(p.MetaBucket, photo.ID, meta)
-	if s.Error != nil {
-		return nil, s.Error
+// newPhotoData encodes the uploaded file and returns it along with its metadata.
+func (p *PhotoManager) newPhotoData(file *FileUpload, profileID string) (*Photo, []byte, error) {
+	photo, err := p.NewPhoto(profileID)
+	if err != nil {
+		return nil, nil, err
 	}
-	s = p.store.Create(p.DataBucket, photo.ID, data)
-	if s.Error != nil {
-		return nil, s.Error
+	photo.Type = file.Ext
+	if err = p.scan(photo, file); err != nil {
+		return nil, nil, err
 	}
-	return photo, nil
+	img, format, data, err := p.encodePhoto(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	photo.Type = format
+	photo.Size = len(data)
+	photo.Hash = photoHash(data)
+	photo.Fingerprint = NewFingerprint(img)
+	photo.Placeholder, err = NewPlaceholder(img)
+	if err != nil {
+		return nil, nil, err
+	}
+	photo.UploadedAt = time.Now()
+	photo.UpdatedAt = time.Now()
+	return photo, data, nil
 }
 
-// handles encoding of the uploaded files into a byte slice
-func (p *PhotoManager) encodePhoto(file *FileUpload) ([]byte, error) {
-	ext := file.Ext
-	switch ext {
-	case "jpg", "jpeg":
-		img, err := jpeg.Decode(*file.Body)
+// save stores the photos of a profile and their data in a single transaction, after
+// checking them for duplicates and against the quota. Either all the photos are
+// saved, or none. The returned photos are the ones given, except for duplicates which
+// are replaced by the stored photos when they are reused.
+func (p *PhotoManager) save(profileID string, photos []*Photo, data [][]byte) ([]*Photo, error) {
+	saved := make([]*Photo, len(photos))
+	err := update(p.db, func(tx *bolt.Tx) error {
+		var fresh []int
+		var size int64
+		batch := make(map[string]*Photo)
+		for i, photo := range photos {
+			saved[i] = photo
+			if p.Duplicates == DuplicateAllow {
+				fresh = append(fresh, i)
+				size += int64(len(data[i]))
+				continue
+			}
+			existing, err := p.duplicate(tx, profileID, photo)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				existing = batch[photo.Hash]
+			}
+			if existing != nil {
+				if p.Duplicates == DuplicateReject {
+					return fmt.Errorf("%w: %s", ErrDuplicatePhoto, existing.ID)
+				}
+				saved[i] = existing
+				continue
+			}
+			batch[photo.Hash] = photo
+			fresh = append(fresh, i)
+			size += int64(len(data[i]))
+		}
+		u := &Usage{ProfileID: profileID}
+		if err := p.readUsage(tx, u); err != nil {
+			return err
+		}
+		if err := p.Quota.check(u, len(fresh), size); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(p.MetaBucket))
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		// this is supposed to increase the quality of the image. But I'm not sure
-		// yet if it is necessary or we should just put nil, which will result into
-		// using default values.
-		opts := jpeg.Options{Quality: 98}
-
-		buf := new(bytes.Buffer)
-		jpeg.Encode(buf, img, &opts)
-		return buf.Bytes(), nil
-	case "png", "PNG":
-		img, err := png.Decode(*file.Body)
+		content, err := tx.CreateBucketIfNotExists([]byte(p.DataBucket))
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for _, i := range fresh {
+			photo := photos[i]
+			m, err := json.Marshal(photo)
+			if err != nil {
+				return err
+			}
+			if err = meta.Put([]byte(photo.ID), m); err != nil {
+				return err
+			}
+			if err = content.Put([]byte(photo.ID), data[i]); err != nil {
+				return err
+			}
+			if err = p.indexHash(tx, photo); err != nil {
+				return err
+			}
+			if photo.Status == StatusPending {
+				if err = p.enqueue(tx, photo, photo.StatusReason); err != nil {
+					return err
+				}
+			}
 		}
-		buf := new(bytes.Buffer)
-		png.Encode(buf, img)
-		return buf.Bytes(), nil
+		u.Photos += len(fresh)
+		u.Bytes += size
+		return p.writeUsage(tx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// handles encoding of the uploaded files into a byte slice according to the Encoding
+// policy, images exceeding the decode Limits are rejected before they are decoded.
+// The decoded image is returned too, along with the format it is encoded in.
+func (p *PhotoManager) encodePhoto(file *FileUpload) (image.Image, string, []byte, error) {
+	img, err := p.Limits.decode(file)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	img = p.Encoding.bound(img)
+	format := p.Encoding.format(strings.ToLower(file.Ext), img)
+	data, err := p.Encoding.encode(img, format, RenditionOriginal)
+	if err != nil {
+		return nil, "", nil, err
 	}
-	return nil, errors.New("mrs: file not supported")
+	return img, format, data, nil
 }
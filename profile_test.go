@@ -2,6 +2,7 @@ package mrs
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
@@ -16,7 +17,7 @@ func cleanUp() {
 func TestProfile_Create(t *testing.T) {
 	defer cleanUp()
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
@@ -26,12 +27,12 @@ func TestProfile_Create(t *testing.T) {
 func TestProfile_Get(t *testing.T) {
 	defer cleanUp()
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
 		}
-		gP, err := NewProfile(id).Get()
+		gP, err := NewProfile(MustParseProfileID(id)).Get()
 		if err != nil {
 			t.Error(err)
 		}
@@ -44,12 +45,12 @@ func TestProfile_Get(t *testing.T) {
 func TestProfile_Update(t *testing.T) {
 	defer cleanUp()
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
 		}
-		gP, err := NewProfile(id).Get()
+		gP, err := NewProfile(MustParseProfileID(id)).Get()
 		if err != nil {
 			t.Error(err)
 		}
@@ -71,15 +72,55 @@ func TestProfile_Update(t *testing.T) {
 	}
 }
 
+func TestProfile_UpdateIf(t *testing.T) {
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Error(err)
+	}
+	first, err := NewProfile(MustParseProfileID(pids[0])).Get()
+	if err != nil {
+		t.Error(err)
+	}
+	second, err := NewProfile(MustParseProfileID(pids[0])).Get()
+	if err != nil {
+		t.Error(err)
+	}
+	first.City = "mwanza"
+	err = first.UpdateIf(first.Version)
+	if err != nil {
+		t.Error(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("Expected 2 actual %d", first.Version)
+	}
+	second.City = "arusha"
+	err = second.UpdateIf(second.Version)
+	if err != ErrProfileModified {
+		t.Errorf("Expected %v actual %v", ErrProfileModified, err)
+	}
+	if second.Version != 1 {
+		t.Errorf("Expected 1 actual %d", second.Version)
+	}
+	up, err := NewProfile(MustParseProfileID(pids[0])).Get()
+	if err != nil {
+		t.Error(err)
+	}
+	if up.City != first.City {
+		t.Errorf("Expected %s actual %s", first.City, up.City)
+	}
+}
+
 func TestProfile_Delete(t *testing.T) {
 	defer cleanUp()
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Create()
 		if err != nil {
 			t.Error(err)
 		}
-		gP, err := NewProfile(id).Get()
+		gP, err := NewProfile(MustParseProfileID(id)).Get()
 		if err != nil {
 			t.Error(err)
 		}
@@ -100,7 +141,7 @@ func TestProfile_Delete(t *testing.T) {
 		}
 	}
 	for _, id := range pids {
-		profile := NewProfile(id)
+		profile := NewProfile(MustParseProfileID(id))
 		err := profile.Deleta()
 		if err != nil {
 			t.Error(err)
@@ -258,3 +299,82 @@ func requestMuliFile() (*http.Request, error) {
 	req.Header.Set("Content-Type", w.FormDataContentType())
 	return req, nil
 }
+
+func TestPhotoManager_Delete(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	defer cleanUp()
+
+	pm.Scanner = verdict(StatusPending, "")
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Scanner = nil
+	if _, err = pm.Crop(photo.ID, &Crop{FocusX: 0.5, FocusY: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := saveTestPhoto(pm, pids[1], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := pm.Delete(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.ID != photo.ID {
+		t.Errorf("expected %s got %s", photo.ID, deleted.ID)
+	}
+	if _, err = pm.Get(photo.ID); !errors.Is(err, ErrPhotoNotFound) {
+		t.Errorf("expected %v got %v", ErrPhotoNotFound, err)
+	}
+	if _, err = pm.Delete(photo.ID); !errors.Is(err, ErrPhotoNotFound) {
+		t.Errorf("expected %v got %v", ErrPhotoNotFound, err)
+	}
+	u, err := pm.Usage(pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Photos != 0 || u.Bytes != 0 {
+		t.Errorf("expected no usage got %+v", u)
+	}
+	items, err := pm.ModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected an empty queue got %d items", len(items))
+	}
+	problems, err := pm.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected nothing left behind got %v", problems)
+	}
+
+	// the hash index no longer knows the deleted photo.
+	again, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == photo.ID {
+		t.Error("expected a new photo")
+	}
+
+	photos, err := pm.Photos(pids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 1 || photos[0].ID != kept.ID {
+		t.Errorf("expected %s got %v", kept.ID, photos)
+	}
+	photos, err = pm.Photos("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 2 {
+		t.Errorf("expected 2 photos got %d", len(photos))
+	}
+}
@@ -0,0 +1,462 @@
+package mrs
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// The standard library only writes baseline jpeg, this is a small progressive jpeg
+// encoder. It uses 4:2:0 chroma subsampling and the example tables of the jpeg
+// specification, and only spectral selection: the dc coefficients come first, then
+// the low frequencies of the luma, the chroma, and the rest of the luma. This is
+// enough for browsers to show a blurry photo early and refine it as data arrives.
+
+// zigzag maps the zigzag order of the coefficients to their natural order.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// baseQuant are the luma and chroma quantization tables for quality 50, in zigzag
+// order.
+var baseQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffSpec is a huffman table as stored in jpeg, the number of codes of every length
+// from 1 to 16 and the symbols in the order of their codes.
+type huffSpec struct {
+	counts  [16]byte
+	symbols []byte
+}
+
+// huffSpecs are the luma dc, luma ac, chroma dc and chroma ac tables.
+var huffSpecs = [4]huffSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffCode is the code of a symbol, size is zero for symbols without a code.
+type huffCode struct {
+	code uint32
+	size uint
+}
+
+type huffTable [256]huffCode
+
+func newHuffTable(s *huffSpec) *huffTable {
+	t := new(huffTable)
+	code, k := uint32(0), 0
+	for size := uint(1); size <= 16; size++ {
+		for i := 0; i < int(s.counts[size-1]); i++ {
+			t[s.symbols[k]] = huffCode{code, size}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// dctTable holds C(u)/2 * cos((2x+1)uπ/16), the factors of the forward dct.
+var dctTable = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return
+}()
+
+// block is the quantized coefficients of an 8x8 block, in zigzag order.
+type block [64]int16
+
+// jpegComponent is a plane of the image, w and h are its size in pixels and stride
+// the number of blocks in a row, which covers whole MCUs.
+type jpegComponent struct {
+	w, h   int
+	stride int
+	blocks []block
+}
+
+type progressiveEncoder struct {
+	w     *bufio.Writer
+	err   error
+	quant [2][64]float64
+	huff  [4]*huffTable
+
+	acc  uint64
+	nacc uint
+}
+
+// progressiveJPEG writes img to w as a progressive jpeg of the given quality.
+func progressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width >= 1<<16 || height >= 1<<16 {
+		return fmt.Errorf("mrs: can't encode a %dx%d image as jpeg", width, height)
+	}
+	e := &progressiveEncoder{w: bufio.NewWriter(w)}
+	quality = clamp(quality, 1, 100)
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range baseQuant {
+		for k, q := range baseQuant[i] {
+			e.quant[i][k] = float64(clamp((q*scale+50)/100, 1, 255))
+		}
+	}
+	for i := range huffSpecs {
+		e.huff[i] = newHuffTable(&huffSpecs[i])
+	}
+
+	mcuW, mcuH := (width+15)/16, (height+15)/16
+	comps := [3]*jpegComponent{
+		{w: width, h: height, stride: 2 * mcuW},
+		{w: (width + 1) / 2, h: (height + 1) / 2, stride: mcuW},
+		{w: (width + 1) / 2, h: (height + 1) / 2, stride: mcuW},
+	}
+	comps[0].blocks = make([]block, 4*mcuW*mcuH)
+	comps[1].blocks = make([]block, mcuW*mcuH)
+	comps[2].blocks = make([]block, mcuW*mcuH)
+	e.transform(img, comps, mcuW, mcuH)
+
+	e.header(width, height)
+	e.scan(comps, []int{0, 1, 2}, 0, 0, mcuW, mcuH)
+	e.scan(comps, []int{0}, 1, 5, mcuW, mcuH)
+	e.scan(comps, []int{1}, 1, 63, mcuW, mcuH)
+	e.scan(comps, []int{2}, 1, 63, mcuW, mcuH)
+	e.scan(comps, []int{0}, 6, 63, mcuW, mcuH)
+	e.write(0xff, 0xd9)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// yccReader returns a function reading the pixels of img in YCbCr, with fast paths
+// for the images returned by the decoders and by shrink.
+func yccReader(img image.Image) func(x, y int) (uint8, uint8, uint8) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint8, uint8, uint8) {
+			c := m.YCbCrAt(x, y)
+			return c.Y, c.Cb, c.Cr
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint8, uint8, uint8) {
+			i := m.PixOffset(x, y)
+			return color.RGBToYCbCr(m.Pix[i], m.Pix[i+1], m.Pix[i+2])
+		}
+	}
+	return func(x, y int) (uint8, uint8, uint8) {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+}
+
+// transform computes the quantized coefficients of every block, one MCU of 16x16
+// pixels at a time. The image is padded by repeating its last row and column.
+func (e *progressiveEncoder) transform(img image.Image, comps [3]*jpegComponent, mcuW, mcuH int) {
+	b := img.Bounds()
+	at := yccReader(img)
+	var ys, cbs, crs [256]float64
+	var in [64]float64
+	for my := 0; my < mcuH; my++ {
+		for mx := 0; mx < mcuW; mx++ {
+			for j := 0; j < 16; j++ {
+				y := min2(b.Min.Y+16*my+j, b.Max.Y-1)
+				for i := 0; i < 16; i++ {
+					x := min2(b.Min.X+16*mx+i, b.Max.X-1)
+					yy, cb, cr := at(x, y)
+					ys[16*j+i], cbs[16*j+i], crs[16*j+i] = float64(yy), float64(cb), float64(cr)
+				}
+			}
+			for k := 0; k < 4; k++ {
+				ox, oy := 8*(k%2), 8*(k/2)
+				for j := 0; j < 8; j++ {
+					for i := 0; i < 8; i++ {
+						in[8*j+i] = ys[16*(oy+j)+ox+i] - 128
+					}
+				}
+				c := comps[0]
+				e.fdct(&in, &c.blocks[(2*my+k/2)*c.stride+2*mx+k%2], 0)
+			}
+			for n, plane := range []*[256]float64{&cbs, &crs} {
+				for j := 0; j < 8; j++ {
+					for i := 0; i < 8; i++ {
+						p := 32*j + 2*i
+						in[8*j+i] = (plane[p]+plane[p+1]+plane[p+16]+plane[p+17])/4 - 128
+					}
+				}
+				c := comps[n+1]
+				e.fdct(&in, &c.blocks[my*c.stride+mx], 1)
+			}
+		}
+	}
+}
+
+// fdct computes the dct of the level shifted samples in, and quantizes it into out
+// with the given table.
+func (e *progressiveEncoder) fdct(in *[64]float64, out *block, table int) {
+	var rows [64]float64
+	for v := 0; v < 8; v++ {
+		for x := 0; x < 8; x++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += dctTable[v][y] * in[8*y+x]
+			}
+			rows[8*v+x] = sum
+		}
+	}
+	for k, n := range zigzag {
+		v, u := n/8, n%8
+		var sum float64
+		for x := 0; x < 8; x++ {
+			sum += dctTable[u][x] * rows[8*v+x]
+		}
+		out[k] = int16(math.Round(sum / e.quant[table][k]))
+	}
+}
+
+func (e *progressiveEncoder) write(p ...byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// marker writes a marker segment, n is the length of its data.
+func (e *progressiveEncoder) marker(m byte, n int) {
+	e.write(0xff, m, byte((n+2)>>8), byte(n+2))
+}
+
+// header writes the quantization tables, the frame and the huffman tables.
+func (e *progressiveEncoder) header(width, height int) {
+	e.write(0xff, 0xd8)
+	e.marker(0xdb, 2*65)
+	for i := range e.quant {
+		e.write(byte(i))
+		for _, q := range e.quant[i] {
+			e.write(byte(q))
+		}
+	}
+	e.marker(0xc2, 6+3*3)
+	e.write(8, byte(height>>8), byte(height), byte(width>>8), byte(width), 3)
+	e.write(1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1)
+	n := 0
+	for _, s := range huffSpecs {
+		n += 17 + len(s.symbols)
+	}
+	e.marker(0xc4, n)
+	for i, s := range huffSpecs {
+		// the class is 0 for dc and 1 for ac, the id 0 for luma and 1 for chroma.
+		e.write(byte(i%2<<4 | i/2))
+		e.write(s.counts[:]...)
+		e.write(s.symbols...)
+	}
+}
+
+// scan writes the coefficients from start to end of the given components. The dc scan
+// interleaves the components by MCU, the others hold a single component.
+func (e *progressiveEncoder) scan(comps [3]*jpegComponent, ids []int, start, end, mcuW, mcuH int) {
+	e.marker(0xda, 4+2*len(ids))
+	e.write(byte(len(ids)))
+	for _, id := range ids {
+		t := byte(min2(id, 1))
+		e.write(byte(id+1), t<<4|t)
+	}
+	e.write(byte(start), byte(end), 0)
+
+	if start == 0 {
+		var pred [3]int16
+		for my := 0; my < mcuH; my++ {
+			for mx := 0; mx < mcuW; mx++ {
+				c := comps[0]
+				for k := 0; k < 4; k++ {
+					e.dc(&c.blocks[(2*my+k/2)*c.stride+2*mx+k%2], &pred[0], 0)
+				}
+				e.dc(&comps[1].blocks[my*mcuW+mx], &pred[1], 2)
+				e.dc(&comps[2].blocks[my*mcuW+mx], &pred[2], 2)
+			}
+		}
+	} else {
+		c := comps[ids[0]]
+		table := 1
+		if ids[0] > 0 {
+			table = 3
+		}
+		for by := 0; by < (c.h+7)/8; by++ {
+			for bx := 0; bx < (c.w+7)/8; bx++ {
+				e.ac(&c.blocks[by*c.stride+bx], start, end, table)
+			}
+		}
+	}
+	e.flush()
+}
+
+func (e *progressiveEncoder) dc(b *block, pred *int16, table int) {
+	diff := int32(b[0]) - int32(*pred)
+	*pred = b[0]
+	size, bits := magnitude(diff)
+	e.emitSymbol(table, byte(size))
+	e.emit(bits, size)
+}
+
+func (e *progressiveEncoder) ac(b *block, start, end, table int) {
+	run := 0
+	for k := start; k <= end; k++ {
+		if b[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			e.emitSymbol(table, 0xf0)
+		}
+		size, bits := magnitude(int32(b[k]))
+		e.emitSymbol(table, byte(run<<4)|byte(size))
+		e.emit(bits, size)
+		run = 0
+	}
+	if run > 0 {
+		// end of band, for this block only.
+		e.emitSymbol(table, 0x00)
+	}
+}
+
+// magnitude returns the number of bits of v and its bits as coded in jpeg, negative
+// values are stored as v-1.
+func magnitude(v int32) (uint, uint32) {
+	a := v
+	if v < 0 {
+		a = -v
+		v--
+	}
+	size := uint(0)
+	for ; a > 0; a >>= 1 {
+		size++
+	}
+	return size, uint32(v)
+}
+
+func (e *progressiveEncoder) emitSymbol(table int, symbol byte) {
+	h := e.huff[table][symbol]
+	if h.size == 0 && e.err == nil {
+		e.err = fmt.Errorf("mrs: no huffman code for symbol %#x", symbol)
+		return
+	}
+	e.emit(h.code, h.size)
+}
+
+// emit writes the n low bits of bits, stuffing a zero after every 0xff byte.
+func (e *progressiveEncoder) emit(bits uint32, n uint) {
+	e.acc = e.acc<<n | uint64(bits&(1<<n-1))
+	e.nacc += n
+	for e.nacc >= 8 {
+		c := byte(e.acc >> (e.nacc - 8))
+		e.write(c)
+		if c == 0xff {
+			e.write(0)
+		}
+		e.nacc -= 8
+	}
+}
+
+// flush pads the last byte of a scan with ones.
+func (e *progressiveEncoder) flush() {
+	if e.nacc > 0 {
+		e.emit(0xff, 8-e.nacc)
+	}
+	e.acc, e.nacc = 0, 0
+}
@@ -0,0 +1,79 @@
+package mrs
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// meanError returns the mean absolute difference of the red, green and blue of a and
+// b, from 0 to 255.
+func meanError(a, b image.Image) float64 {
+	ba, bb := a.Bounds(), b.Bounds()
+	var sum, n float64
+	for y := 0; y < ba.Dy(); y++ {
+		for x := 0; x < ba.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ba.Min.X+x, ba.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []int{int(r1) - int(r2), int(g1) - int(g2), int(b1) - int(b2)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += float64(d >> 8)
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+func TestProgressiveJPEG(t *testing.T) {
+	photo, _, _ := testImages(t)
+	gradient := image.NewRGBA(image.Rect(3, 5, 40, 26))
+	for y := 5; y < 26; y++ {
+		for x := 3; x < 40; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(6 * x), uint8(10 * y), 128, 255})
+		}
+	}
+	sample := []struct {
+		name string
+		img  image.Image
+	}{
+		{"photo", photo},
+		{"gradient", gradient},
+		{"gray", filled(17, 1, color.Gray{200})},
+	}
+	for _, v := range sample {
+		buf := new(bytes.Buffer)
+		if err := progressiveJPEG(buf, v.img, 90); err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte{0xff, 0xc2}) {
+			t.Errorf("%s: expected a progressive frame", v.name)
+		}
+		img, err := jpeg.Decode(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if img.Bounds().Dx() != v.img.Bounds().Dx() || img.Bounds().Dy() != v.img.Bounds().Dy() {
+			t.Errorf("%s: expected %v got %v", v.name, v.img.Bounds().Size(), img.Bounds().Size())
+		}
+		if e := meanError(v.img, img); e > 4 {
+			t.Errorf("%s: expected a mean error under 4 got %.2f", v.name, e)
+		}
+	}
+
+	// a lower quality gives a smaller file.
+	high, low := new(bytes.Buffer), new(bytes.Buffer)
+	progressiveJPEG(high, photo, 90)
+	progressiveJPEG(low, photo, 30)
+	if low.Len() >= high.Len() {
+		t.Errorf("expected quality 30 to be smaller than 90, got %d and %d", low.Len(), high.Len())
+	}
+
+	if err := progressiveJPEG(new(bytes.Buffer), image.NewRGBA(image.Rect(0, 0, 0, 4)), 90); err == nil {
+		t.Error("expected an error for an empty image")
+	}
+}
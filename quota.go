@@ -0,0 +1,127 @@
+package mrs
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+const defaultUsageBucket = "usage"
+
+var (
+	// ErrQuotaExceeded is returned when saving photos would take a profile past its
+	// storage quota.
+	ErrQuotaExceeded = errors.New("sorry: the photo storage quota is exceeded")
+)
+
+// Quota limits the storage used by the photos of a single profile, their renditions
+// count toward MaxBytes. Zero values mean no limit.
+type Quota struct {
+	MaxPhotos int
+	MaxBytes  int64
+}
+
+// Usage is the storage used by the photos of a profile, along with its quota.
+type Usage struct {
+	XMLName   xml.Name `json:"-" xml:"usage"`
+	ProfileID string   `json:"profile_id" xml:"profile_id"`
+	Photos    int      `json:"photos" xml:"photos"`
+	Bytes     int64    `json:"bytes" xml:"bytes"`
+	MaxPhotos int      `json:"max_photos,omitempty" xml:"max_photos,omitempty"`
+	MaxBytes  int64    `json:"max_bytes,omitempty" xml:"max_bytes,omitempty"`
+}
+
+// check returns an error if adding photos of the given total size goes past q.
+func (q Quota) check(u *Usage, photos int, bytes int64) error {
+	if q.MaxPhotos > 0 && u.Photos+photos > q.MaxPhotos {
+		return fmt.Errorf("%w: at most %d photos are allowed", ErrQuotaExceeded, q.MaxPhotos)
+	}
+	if q.MaxBytes > 0 && u.Bytes+bytes > q.MaxBytes {
+		return fmt.Errorf("%w: at most %d bytes are allowed", ErrQuotaExceeded, q.MaxBytes)
+	}
+	return nil
+}
+
+// storedSize is the storage used by the photo, its data along with its renditions.
+func (p *Photo) storedSize() int64 {
+	return int64(p.Size) + p.RenditionsSize
+}
+
+// Usage returns the storage used by the photos of the profile.
+func (p *PhotoManager) Usage(profileID string) (*Usage, error) {
+	u := &Usage{ProfileID: profileID}
+	err := view(p.db, func(tx *bolt.Tx) error {
+		return p.readUsage(tx, u)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	u.MaxPhotos, u.MaxBytes = p.Quota.MaxPhotos, p.Quota.MaxBytes
+	return u, nil
+}
+
+func (p *PhotoManager) readUsage(tx *bolt.Tx, u *Usage) error {
+	b := tx.Bucket([]byte(p.UsageBucket))
+	if b == nil {
+		return nil
+	}
+	data := b.Get([]byte(u.ProfileID))
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(data, u)
+}
+
+func (p *PhotoManager) writeUsage(tx *bolt.Tx, u *Usage) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(p.UsageBucket))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(u.ProfileID), data)
+}
+
+// RecountUsage recomputes the usage of every profile from the photo metadata. It is
+// meant for databases written before usage was tracked, or after repairs.
+func (p *PhotoManager) RecountUsage() error {
+	return update(p.db, func(tx *bolt.Tx) error {
+		usage := make(map[string]*Usage)
+		if meta := tx.Bucket([]byte(p.MetaBucket)); meta != nil {
+			err := meta.ForEach(func(k, v []byte) error {
+				photo := new(Photo)
+				if err := json.Unmarshal(v, photo); err != nil {
+					return err
+				}
+				u, ok := usage[photo.UploadedBy]
+				if !ok {
+					u = &Usage{ProfileID: photo.UploadedBy}
+					usage[photo.UploadedBy] = u
+				}
+				u.Photos++
+				u.Bytes += photo.storedSize()
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte(p.UsageBucket)) != nil {
+			if err := tx.DeleteBucket([]byte(p.UsageBucket)); err != nil {
+				return err
+			}
+		}
+		for _, u := range usage {
+			if err := p.writeUsage(tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
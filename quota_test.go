@@ -0,0 +1,129 @@
+package mrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestPhotoManager_Quota(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	profileID := pids[0]
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Quota = Quota{MaxPhotos: 2}
+	// the same picture is uploaded every time.
+	pm.Duplicates = DuplicateAllow
+	defer cleanUp()
+
+	req, err := requestMuliFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ups, err := pm.GetUploadFiles(req, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pm.SaveMultiple(ups, profileID)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %v got %v", ErrQuotaExceeded, err)
+	}
+	u, err := pm.Usage(profileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Photos != 0 || u.Bytes != 0 {
+		t.Errorf("expected nothing to be saved, got %d photos and %d bytes", u.Photos, u.Bytes)
+	}
+
+	var size int64
+	for i := 0; i < 3; i++ {
+		req, err := requestWithFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, err := pm.GetSingleFileUpload(req, "profile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		photo, err := pm.SaveSingle(up, profileID)
+		if i < 2 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += int64(photo.Size)
+			continue
+		}
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("expected %v got %v", ErrQuotaExceeded, err)
+		}
+	}
+	u, err = pm.Usage(profileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Photos != 2 || u.Bytes != size || u.MaxPhotos != 2 {
+		t.Errorf("expected 2 photos of %d bytes, got %+v", size, u)
+	}
+
+	err = pm.RecountUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recount, err := pm.Usage(profileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recount.Photos != u.Photos || recount.Bytes != u.Bytes {
+		t.Errorf("expected %+v got %+v", u, recount)
+	}
+}
+
+func TestHandlers_Usage(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Photos().Quota = Quota{MaxBytes: 1}
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/usage/{id}", handle.Usage)
+	h.HandleFunc("/profile/uploads/{id}", handle.FileUploads)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := ajaxWithMultipleFiles(fmt.Sprintf("/profile/uploads/%s", pids[0]), "photos", t)
+	if req != nil {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusInsufficientStorage {
+			t.Errorf("Expected %d actual %d", http.StatusInsufficientStorage, w.Code)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", fmt.Sprintf("/profile/usage/%s", pids[0]), nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	u := new(Usage)
+	err = json.Unmarshal(w.Body.Bytes(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ProfileID != pids[0] || u.Photos != 0 || u.MaxBytes != 1 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+}
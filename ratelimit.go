@@ -0,0 +1,190 @@
+package mrs
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxMemoryBuckets is the number of buckets kept by a MemoryRateStore, past it the
+// least recently seen bucket is dropped for every new one.
+const maxMemoryBuckets = 10000
+
+var (
+	// ErrRateLimited is the message when a client sends too many requests.
+	ErrRateLimited = errors.New("sorry: too many requests, try again later")
+)
+
+// Limit is the rate of a token bucket. The bucket holds up to Burst tokens and is
+// refilled at Rate tokens per second, every request takes one token. A zero Rate
+// means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Every returns a Limit allowing one request every interval, with bursts of up to
+// burst requests.
+func Every(interval time.Duration, burst int) Limit {
+	if interval <= 0 {
+		return Limit{}
+	}
+	return Limit{Rate: float64(time.Second) / float64(interval), Burst: burst}
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// RateStore keeps the token buckets of the rate limiter. The default store is in
+// process, implement RateStore to share the buckets between several servers.
+type RateStore interface {
+	// Take takes a token from the bucket with the given key. It reports whether a
+	// token was available, and if not how long to wait until one is.
+	Take(key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// MemoryRateStore is a RateStore keeping the buckets in memory. It keeps at most
+// maxMemoryBuckets buckets, the least recently seen ones are dropped first, and the
+// buckets which are full again are dropped as they become the least recently seen.
+type MemoryRateStore struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+
+	// seen orders the buckets from the most to the least recently seen.
+	seen *list.List
+}
+
+type tokenBucket struct {
+	key    string
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateStore returns an empty MemoryRateStore.
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{buckets: make(map[string]*list.Element), seen: list.New()}
+}
+
+// Take implements RateStore.
+func (s *MemoryRateStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	var b *tokenBucket
+	if e, ok := s.buckets[key]; ok {
+		s.seen.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+		b.limit = limit
+	} else {
+		if len(s.buckets) >= maxMemoryBuckets {
+			s.drop(s.seen.Back())
+		}
+		b = &tokenBucket{key: key, limit: limit, tokens: limit.burst(), last: now}
+		s.buckets[key] = s.seen.PushFront(b)
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second)), nil
+}
+
+// expire drops the least recently seen buckets which are full, they are the same as
+// new ones. It stops at the first one which is not, so every call is cheap.
+func (s *MemoryRateStore) expire(now time.Time) {
+	for e := s.seen.Back(); e != nil; e = s.seen.Back() {
+		b := e.Value.(*tokenBucket)
+		b.refill(now)
+		if b.tokens < b.limit.burst() {
+			return
+		}
+		s.drop(e)
+	}
+}
+
+func (s *MemoryRateStore) drop(e *list.Element) {
+	s.seen.Remove(e)
+	delete(s.buckets, e.Value.(*tokenBucket).key)
+}
+
+// refill adds the tokens earned since the bucket was last seen, at the rate of the
+// limit the bucket was last taken from with.
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.limit.burst(), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// RateLimits configures the rate limiting of the handlers which modify profiles, and
+// of Report. Requests are limited per profile and client ip, a zero Limit disables
+// limiting for the handler.
+type RateLimits struct {
+	Update Limit
+
+	// ProfilePic also limits Crop, which is counted separately.
+	ProfilePic  Limit
+	FileUploads Limit
+	Report      Limit
+
+	// Store keeps the buckets, NewHandlers sets it to a MemoryRateStore.
+	Store RateStore
+
+	// ClientIP returns the ip of the client, the default uses the remote address of
+	// the request. Set it when running behind a trusted proxy.
+	ClientIP func(r *http.Request) string
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the ip of the client of the request, see RateLimits.ClientIP.
+func (h *Handlers) clientIP(r *http.Request) string {
+	if h.RateLimits.ClientIP != nil {
+		return h.RateLimits.ClientIP(r)
+	}
+	return remoteIP(r)
+}
+
+// allowRate takes a token for the request from the bucket of the handler, keyed by
+// the profile in the url path and the client ip. When the bucket is empty, it
+// responds with 429 Too Many Requests and a Retry-After header, and returns false.
+//
+// Errors of the store are not fatal, requests are let through when the limiter
+// can't tell.
+func (h *Handlers) allowRate(w http.ResponseWriter, r *http.Request, format, name string, limit Limit) bool {
+	if limit.Rate <= 0 || h.RateLimits.Store == nil {
+		return true
+	}
+	id, err := h.profileID(r)
+	if err != nil {
+		// the request is rejected later on anyway.
+		return true
+	}
+	key := name + ":" + id.String() + ":" + h.clientIP(r)
+	ok, wait, err := h.RateLimits.Store.Take(key, limit, time.Now())
+	if err != nil || ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	h.renderError(w, format, newError(http.StatusTooManyRequests, CodeRateLimited, ErrRateLimited))
+	return false
+}
@@ -0,0 +1,120 @@
+package mrs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestMemoryRateStore(t *testing.T) {
+	s := NewMemoryRateStore()
+	limit := Every(time.Second, 2)
+	now := time.Now()
+
+	take := func(key string, at time.Time) (bool, time.Duration) {
+		ok, wait, err := s.Take(key, limit, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, wait
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := take("a", now); !ok {
+			t.Errorf("expected request %d to be allowed", i)
+		}
+	}
+	ok, wait := take("a", now)
+	if ok {
+		t.Error("expected the burst to be exhausted")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait %v got %v", time.Second, wait)
+	}
+	if ok, _ := take("b", now); !ok {
+		t.Error("expected the buckets to be independent")
+	}
+	if ok, _ := take("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("expected half a token not to be enough")
+	}
+	if ok, _ := take("a", now.Add(time.Second)); !ok {
+		t.Error("expected the bucket to be refilled")
+	}
+}
+
+func TestMemoryRateStore_Eviction(t *testing.T) {
+	s := NewMemoryRateStore()
+	slow := Every(time.Hour, 1)
+	fast := Every(time.Millisecond, 1)
+	now := time.Now()
+
+	if ok, _, _ := s.Take("slow", slow, now); !ok {
+		t.Fatal("expected the first request to be allowed")
+	}
+	// buckets of other limits don't refill the slow one.
+	for i := 0; i < 10; i++ {
+		s.Take(fmt.Sprint("fast", i), fast, now.Add(time.Second))
+	}
+	if ok, _, _ := s.Take("slow", slow, now.Add(time.Second)); ok {
+		t.Error("expected the slow bucket to still be empty")
+	}
+
+	// full buckets are dropped, and the store never grows past its cap.
+	for i := 0; i < 2*maxMemoryBuckets; i++ {
+		s.Take(fmt.Sprint("key", i), slow, now.Add(2*time.Second))
+	}
+	if n := len(s.buckets); n != maxMemoryBuckets || s.seen.Len() != n {
+		t.Errorf("expected %d buckets got %d", maxMemoryBuckets, n)
+	}
+	if _, ok := s.buckets["fast0"]; ok {
+		t.Error("expected the full buckets to be dropped")
+	}
+}
+
+func TestHandlers_RateLimits(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.RateLimits.Update = Every(time.Minute, 1)
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/update/{id}", handle.Update)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(ip string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"city":"mwanza"}`)
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/profile/update/%s", pids[0]), body)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("If-Match", "*")
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	if w := update("10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+	w := update("10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected %d actual %d", http.StatusTooManyRequests, w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "60" {
+		t.Errorf("Expected Retry-After 60 actual %s", ra)
+	}
+	if !strings.Contains(w.Body.String(), CodeRateLimited) {
+		t.Errorf("Expected %s to contain %s", w.Body.String(), CodeRateLimited)
+	}
+	if w := update("10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,357 @@
+package mrs
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	defaultReportBucket = "reports"
+
+	// maxReportText is the maximum length in characters of the text of a report.
+	maxReportText = 2000
+)
+
+// ReportReason is why a profile or photo is reported.
+type ReportReason string
+
+// The reasons of reports.
+const (
+	ReasonSpam          ReportReason = "spam"
+	ReasonNudity        ReportReason = "nudity"
+	ReasonHarassment    ReportReason = "harassment"
+	ReasonHate          ReportReason = "hate"
+	ReasonViolence      ReportReason = "violence"
+	ReasonImpersonation ReportReason = "impersonation"
+	ReasonOther         ReportReason = "other"
+)
+
+func (r ReportReason) valid() bool {
+	switch r {
+	case ReasonSpam, ReasonNudity, ReasonHarassment, ReasonHate, ReasonViolence,
+		ReasonImpersonation, ReasonOther:
+		return true
+	}
+	return false
+}
+
+var (
+	// ErrInvalidReport is the message when a report has no valid reason or its text
+	// is too long.
+	ErrInvalidReport = errors.New("sorry: invalid report")
+
+	// ErrAlreadyReported is the message when the reporter already reported the same
+	// profile or photo.
+	ErrAlreadyReported = errors.New("sorry: you already reported this")
+)
+
+// Report is a complaint about a profile, or one of its photos when PhotoID is set.
+type Report struct {
+	XMLName   xml.Name     `json:"-" xml:"report"`
+	ID        string       `json:"id" xml:"id"`
+	Reporter  string       `json:"reporter" xml:"reporter"`
+	ProfileID string       `json:"profile_id" xml:"profile_id"`
+	PhotoID   string       `json:"photo_id,omitempty" xml:"photo_id,omitempty"`
+	Reason    ReportReason `json:"reason" xml:"reason"`
+	Text      string       `json:"text,omitempty" xml:"text,omitempty"`
+	CreatedAt time.Time    `json:"created_at" xml:"created_at"`
+
+	// Escalated is true once the profile or photo got enough reports to be escalated,
+	// see ReportPolicy.
+	Escalated bool `json:"escalated" xml:"escalated"`
+}
+
+// target is the key of what the report is about.
+func (r *Report) target() string {
+	if r.PhotoID != "" {
+		return "photo:" + r.PhotoID
+	}
+	return "profile:" + r.ProfileID
+}
+
+func (r *Report) validate() error {
+	if r.Reporter == "" {
+		return fmt.Errorf("%w: the reporter is missing", ErrInvalidReport)
+	}
+	if !r.Reason.valid() {
+		return fmt.Errorf("%w: unknown reason %q", ErrInvalidReport, r.Reason)
+	}
+	if utf8.RuneCountInString(r.Text) > maxReportText {
+		return fmt.Errorf("%w: the text is longer than %d characters", ErrInvalidReport, maxReportText)
+	}
+	return nil
+}
+
+// ReportPolicy decides when reports are escalated. Only one report per reporter is
+// counted for every profile or photo, and zero thresholds never escalate.
+type ReportPolicy struct {
+	// Photo is the number of reports after which a photo is flagged, it is then hidden
+	// to others than its owner and queued for moderation, see Flag. Photos are flagged
+	// only once, so that once a moderator approves a photo more reports don't hide it
+	// again.
+	Photo int
+
+	// Profile is the number of reports after which the reports about a profile are
+	// escalated, moderators find them with ReportFilter.Escalated.
+	Profile int
+}
+
+// DefaultReportPolicy is the report policy of NewPhotoManager.
+var DefaultReportPolicy = ReportPolicy{
+	Photo:   3,
+	Profile: 5,
+}
+
+// reportTarget is how the reports about a profile or photo are counted.
+type reportTarget struct {
+	Reporters []string   `json:"reporters"`
+	Escalated *time.Time `json:"escalated,omitempty"`
+}
+
+func (t *reportTarget) has(reporter string) bool {
+	for _, v := range t.Reporters {
+		if v == reporter {
+			return true
+		}
+	}
+	return false
+}
+
+// targetBucket is where the reports are counted, next to the reports.
+func (p *PhotoManager) targetBucket() []byte {
+	return []byte(p.ReportBucket + ".targets")
+}
+
+// Report saves the report and escalates what it is about when it gets enough reports,
+// see ReportPolicy. The ID and CreatedAt of the report are set. The Reporter is
+// required, a reporter can report the same profile or photo only once, the error is
+// ErrAlreadyReported after.
+func (p *PhotoManager) Report(r *Report) (*Report, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	id, err := p.IDs.NewID()
+	if err != nil {
+		return nil, err
+	}
+	r.ID = id
+	r.CreatedAt = time.Now()
+	err = update(p.db, func(tx *bolt.Tx) error {
+		reports, err := tx.CreateBucketIfNotExists([]byte(p.ReportBucket))
+		if err != nil {
+			return err
+		}
+		targets, err := tx.CreateBucketIfNotExists(p.targetBucket())
+		if err != nil {
+			return err
+		}
+		key := []byte(r.target())
+		t := new(reportTarget)
+		if data := targets.Get(key); data != nil {
+			if err = json.Unmarshal(data, t); err != nil {
+				return err
+			}
+		}
+		if t.has(r.Reporter) {
+			return ErrAlreadyReported
+		}
+		t.Reporters = append(t.Reporters, r.Reporter)
+
+		threshold := p.Escalation.Profile
+		if r.PhotoID != "" {
+			threshold = p.Escalation.Photo
+		}
+		if t.Escalated == nil && threshold > 0 && len(t.Reporters) >= threshold {
+			t.Escalated = &r.CreatedAt
+			if r.PhotoID != "" {
+				reason := fmt.Sprintf("reported %d times, last for %s", len(t.Reporters), r.Reason)
+				if _, err = p.flag(tx, r.PhotoID, reason); err != nil {
+					return err
+				}
+			}
+		}
+		r.Escalated = t.Escalated != nil
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if err = targets.Put(key, data); err != nil {
+			return err
+		}
+		data, err = json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return reports.Put([]byte(r.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReportFilter selects the reports returned by Reports, zero values select all.
+type ReportFilter struct {
+	ProfileID string
+	PhotoID   string
+
+	// Escalated selects only the reports about escalated profiles and photos.
+	Escalated bool
+}
+
+func (f *ReportFilter) match(r *Report) bool {
+	return (f.ProfileID == "" || f.ProfileID == r.ProfileID) &&
+		(f.PhotoID == "" || f.PhotoID == r.PhotoID) &&
+		(!f.Escalated || r.Escalated)
+}
+
+// Reports returns the reports selected by the filter, the newest first. The Escalated
+// field of the reports tells whether what they are about is escalated now.
+func (p *PhotoManager) Reports(f ReportFilter) ([]*Report, error) {
+	var rst []*Report
+	err := view(p.db, func(tx *bolt.Tx) error {
+		reports := tx.Bucket([]byte(p.ReportBucket))
+		targets := tx.Bucket(p.targetBucket())
+		if reports == nil || targets == nil {
+			return nil
+		}
+		return reports.ForEach(func(k, v []byte) error {
+			r := new(Report)
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			t := new(reportTarget)
+			if data := targets.Get([]byte(r.target())); data != nil {
+				if err := json.Unmarshal(data, t); err != nil {
+					return err
+				}
+			}
+			r.Escalated = t.Escalated != nil
+			if f.match(r) {
+				rst = append(rst, r)
+			}
+			return nil
+		})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.SliceStable(rst, func(i, j int) bool {
+		return rst[i].CreatedAt.After(rst[j].CreatedAt)
+	})
+	return rst, nil
+}
+
+// Report lets the caller report the profile whose id is in the url path, or one of its
+// photos. The request body is json like
+//
+//	{"photo":"...","reason":"nudity","text":"..."}
+//
+// where photo is optional and reason is one of the ReportReason values. The saved
+// report is sent back as json or xml with 201 Created.
+//
+// When the handlers have an Authenticator, only signed in callers can report.
+// Otherwise the reporter is the ip of the client, like ip:192.0.2.1, so that reports
+// from different clients count as different reporters.
+func (h *Handlers) Report(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "POST") {
+		return
+	}
+	if !h.allowRate(w, r, format, "report", h.RateLimits.Report) {
+		return
+	}
+	who, ok := h.authenticate(w, r, format)
+	if !ok {
+		return
+	}
+	p, err := h.getProfile(r)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	form := struct {
+		Photo  string       `json:"photo"`
+		Reason ReportReason `json:"reason"`
+		Text   string       `json:"text"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&form)
+	if err != nil && err != io.EOF {
+		h.renderError(w, format, newError(http.StatusBadRequest, CodeValidation, errors.New("bad report data")))
+		return
+	}
+	if form.Photo != "" {
+		photo, err := h.pm.Get(form.Photo)
+		if err == nil && photo.UploadedBy != p.ID {
+			err = ErrPhotoNotFound
+		}
+		if err != nil {
+			h.renderError(w, format, httpError(err, ErrInternal))
+			return
+		}
+	}
+	report := &Report{
+		ProfileID: p.ID,
+		PhotoID:   form.Photo,
+		Reason:    form.Reason,
+		Text:      form.Text,
+	}
+	if who != nil {
+		report.Reporter = who.UserID
+	} else {
+		report.Reporter = "ip:" + h.clientIP(r)
+	}
+	report, err = h.pm.Report(report)
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	h.render(w, format, http.StatusCreated, report)
+}
+
+// Reports sends the reports as json or xml, the newest first. They can be filtered
+// with the profile, photo and escalated query parameters, like ?escalated=true. Only
+// moderators can see the reports.
+func (h *Handlers) Reports(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r, mimeJSON, mimeXML)
+	if format == "" {
+		notAcceptable(w, r, mimeJSON, mimeXML)
+		return
+	}
+	if !h.allowMethod(w, r, format, "GET", "HEAD") {
+		return
+	}
+	if _, ok := h.moderator(w, r, format); !ok {
+		return
+	}
+	q := r.URL.Query()
+	reports, err := h.pm.Reports(ReportFilter{
+		ProfileID: q.Get("profile"),
+		PhotoID:   q.Get("photo"),
+		Escalated: q.Get("escalated") == "true",
+	})
+	if err != nil {
+		h.renderError(w, format, httpError(err, ErrInternal))
+		return
+	}
+	if reports == nil {
+		reports = []*Report{}
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	h.render(w, format, http.StatusOK, reports)
+}
@@ -0,0 +1,240 @@
+package mrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestPhotoManager_Report(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Escalation = ReportPolicy{Photo: 2, Profile: 2}
+	defer cleanUp()
+
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pm.Report(&Report{Reporter: pids[1], ProfileID: pids[0], Reason: "boring"})
+	if !errors.Is(err, ErrInvalidReport) {
+		t.Errorf("expected %v got %v", ErrInvalidReport, err)
+	}
+	_, err = pm.Report(&Report{Reporter: pids[1], ProfileID: pids[0], Reason: ReasonOther, Text: strings.Repeat("a", maxReportText+1)})
+	if !errors.Is(err, ErrInvalidReport) {
+		t.Errorf("expected %v got %v", ErrInvalidReport, err)
+	}
+	_, err = pm.Report(&Report{ProfileID: pids[0], Reason: ReasonSpam})
+	if !errors.Is(err, ErrInvalidReport) {
+		t.Errorf("expected %v got %v", ErrInvalidReport, err)
+	}
+
+	r, err := pm.Report(&Report{Reporter: pids[1], ProfileID: pids[0], PhotoID: photo.ID, Reason: ReasonNudity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID == "" || r.CreatedAt.IsZero() || r.Escalated {
+		t.Errorf("unexpected report %+v", r)
+	}
+	_, err = pm.Report(&Report{Reporter: pids[1], ProfileID: pids[0], PhotoID: photo.ID, Reason: ReasonSpam})
+	if !errors.Is(err, ErrAlreadyReported) {
+		t.Errorf("expected %v got %v", ErrAlreadyReported, err)
+	}
+	stored, err := pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Approved() {
+		t.Error("expected the photo to be shown below the threshold")
+	}
+
+	r, err = pm.Report(&Report{Reporter: pids[2], ProfileID: pids[0], PhotoID: photo.ID, Reason: ReasonNudity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Escalated {
+		t.Error("expected the report to be escalated")
+	}
+	stored, err = pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusPending {
+		t.Errorf("expected the photo to be flagged got %q", stored.Status)
+	}
+	items, err := pm.ModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Photo.ID != photo.ID {
+		t.Errorf("expected the photo to be queued got %+v", items)
+	}
+
+	// a report about the profile is counted apart from the ones about its photos.
+	_, err = pm.Report(&Report{Reporter: pids[1], ProfileID: pids[0], Reason: ReasonImpersonation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := pm.Reports(ReportFilter{ProfileID: pids[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 || reports[0].PhotoID != "" || reports[0].Escalated {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+	reports, err = pm.Reports(ReportFilter{Escalated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected 2 escalated reports got %d", len(reports))
+	}
+	for _, v := range reports {
+		if v.PhotoID != photo.ID || !v.Escalated {
+			t.Errorf("unexpected escalated report %+v", v)
+		}
+	}
+}
+
+func TestHandlers_Report(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.pm.Escalation = ReportPolicy{Photo: 1}
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}/report", handle.Report)
+	h.HandleFunc("/reports", handle.Reports)
+
+	defer cleanUp()
+	photo, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := saveTestPhoto(handle.pm, pids[1], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, user, role, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-Role", role)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	path := fmt.Sprintf("/profile/%s/report", pids[0])
+	sample := []struct {
+		user, body string
+		code       int
+	}{
+		{"", `{"reason":"spam"}`, http.StatusUnauthorized},
+		{pids[1], `{"reason":"boring"}`, http.StatusBadRequest},
+		{pids[1], `not json`, http.StatusBadRequest},
+		{pids[1], fmt.Sprintf(`{"photo":%q,"reason":"nudity"}`, other.ID), http.StatusNotFound},
+		{pids[1], `{"reason":"spam","text":"sells stuff"}`, http.StatusCreated},
+		{pids[1], `{"reason":"spam"}`, http.StatusConflict},
+		{pids[2], fmt.Sprintf(`{"photo":%q,"reason":"nudity"}`, photo.ID), http.StatusCreated},
+	}
+	for _, v := range sample {
+		if w := do("POST", path, v.user, "", v.body); w.Code != v.code {
+			t.Errorf("%q %s: expected %d actual %d %s", v.user, v.body, v.code, w.Code, w.Body.String())
+		}
+	}
+	stored, err := handle.pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Approved() {
+		t.Error("expected the reported photo to be hidden")
+	}
+
+	if w := do("GET", "/reports", pids[1], "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d actual %d", http.StatusForbidden, w.Code)
+	}
+	w := do("GET", "/reports?escalated=true", pids[2], RoleModerator, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+	var reports []*Report
+	if err = json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].PhotoID != photo.ID || reports[0].Reporter != pids[2] {
+		t.Errorf("unexpected reports %s", w.Body.String())
+	}
+	w = do("GET", fmt.Sprintf("/reports?profile=%s", pids[0]), pids[2], RoleModerator, "")
+	reports = nil
+	if err = json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected 2 reports got %s", w.Body.String())
+	}
+}
+
+func TestHandlers_ReportWithoutAuth(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.pm.Escalation = ReportPolicy{Photo: 2}
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/{id}/report", handle.Report)
+
+	defer cleanUp()
+	photo, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/profile/%s/report", pids[0])
+	body := fmt.Sprintf(`{"photo":%q,"reason":"nudity"}`, photo.ID)
+	sample := []struct {
+		addr string
+		code int
+	}{
+		{"192.0.2.1:1234", http.StatusCreated},
+		{"192.0.2.1:5678", http.StatusConflict},
+		{"192.0.2.2:1234", http.StatusCreated},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		r.RemoteAddr = v.addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: expected %d actual %d %s", v.addr, v.code, w.Code, w.Body.String())
+		}
+	}
+	stored, err := handle.pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Approved() {
+		t.Error("expected the anonymous reports to escalate the photo")
+	}
+}
@@ -0,0 +1,122 @@
+package mrs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// PhotoStatus is the state of a photo in the review of uploads. Photos are only shown
+// to others than their owner once they are approved.
+type PhotoStatus string
+
+// The states of photos. Photos stored before uploads were scanned have no status,
+// they are approved.
+const (
+	StatusPending  PhotoStatus = "pending"
+	StatusApproved PhotoStatus = "approved"
+	StatusRejected PhotoStatus = "rejected"
+)
+
+var (
+	// ErrPhotoRejected is the message when the Scanner rejects an upload.
+	ErrPhotoRejected = errors.New("sorry: the photo was rejected")
+
+	// ErrScanFailed is the message when the Scanner can't tell whether an upload is
+	// safe, the upload is refused so that nothing escapes scanning.
+	ErrScanFailed = errors.New("sorry: the photo could not be scanned, try again later")
+)
+
+// Verdict is the result of scanning a photo.
+type Verdict struct {
+	Status PhotoStatus `json:"status"`
+
+	// Reason tells why the photo is pending or rejected, like the name of the virus
+	// found.
+	Reason string `json:"reason,omitempty"`
+
+	// By is who reviewed the photo, the id of a moderator. It is empty for scanners.
+	By string `json:"by,omitempty"`
+}
+
+// Scanner scans uploads before they are saved, for malware or to moderate their
+// content. The photo has its ID, UploadedBy and Type set, and data is the file as it
+// was uploaded.
+//
+// A Scanner rejects uploads synchronously with StatusRejected, they are not saved and
+// the error is ErrPhotoRejected. Scanners which take longer, like human review, return
+// StatusPending and later call SetStatus with the id of the photo, the photo is saved
+// in quarantine until then.
+type Scanner interface {
+	Scan(photo *Photo, data io.Reader) (*Verdict, error)
+}
+
+// ScannerFunc is an adapter which allows ordinary functions to be used as Scanner.
+type ScannerFunc func(photo *Photo, data io.Reader) (*Verdict, error)
+
+// Scan calls f(photo, data).
+func (f ScannerFunc) Scan(photo *Photo, data io.Reader) (*Verdict, error) {
+	return f(photo, data)
+}
+
+// Approved returns true if the photo can be shown to others than its owner.
+func (p *Photo) Approved() bool {
+	return p.Status == "" || p.Status == StatusApproved
+}
+
+// scan runs the Scanner on the uploaded file and sets the status of the photo. The
+// file is rewound for decoding.
+func (p *PhotoManager) scan(photo *Photo, file *FileUpload) error {
+	if p.Scanner == nil {
+		return nil
+	}
+	body := *file.Body
+	v, err := p.Scanner.Scan(photo, body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	if _, err = body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch v.Status {
+	case StatusApproved, StatusPending:
+	case StatusRejected:
+		if v.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrPhotoRejected, v.Reason)
+		}
+		return ErrPhotoRejected
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrScanFailed, v.Status)
+	}
+	photo.Status = v.Status
+	photo.StatusReason = v.Reason
+	return nil
+}
+
+// SetStatus records the verdict of a Scanner or a moderator on a photo. Pending photos
+// are added to the moderation queue, and the others removed from it. Rejected photos
+// stay stored, hidden to others than their owner, so that they can be reviewed.
+func (p *PhotoManager) SetStatus(id string, v *Verdict) (*Photo, error) {
+	switch v.Status {
+	case StatusPending, StatusApproved, StatusRejected:
+	default:
+		return nil, fmt.Errorf("mrs: unknown photo status %q", v.Status)
+	}
+	return p.updatePhoto(id, func(tx *bolt.Tx, photo *Photo) error {
+		now := time.Now()
+		photo.Status = v.Status
+		photo.StatusReason = v.Reason
+		photo.UpdatedAt = now
+		if v.By != "" {
+			photo.ReviewedBy = v.By
+			photo.ReviewedAt = &now
+		}
+		if v.Status == StatusPending {
+			return p.enqueue(tx, photo, v.Reason)
+		}
+		return p.dequeue(tx, id)
+	})
+}
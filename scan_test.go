@@ -0,0 +1,141 @@
+package mrs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func verdict(status PhotoStatus, reason string) Scanner {
+	return ScannerFunc(func(photo *Photo, data io.Reader) (*Verdict, error) {
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return nil, err
+		}
+		return &Verdict{Status: status, Reason: reason}, nil
+	})
+}
+
+func TestPhotoManager_Scanner(t *testing.T) {
+	os.MkdirAll("db", 0700)
+	pm := NewPhotoManager("db/media.db", "meta", "data")
+	pm.Duplicates = DuplicateAllow
+	defer cleanUp()
+
+	pm.Scanner = verdict(StatusRejected, "Eicar-Test-Signature")
+	_, err := saveTestPhoto(pm, pids[0], t)
+	if !errors.Is(err, ErrPhotoRejected) {
+		t.Errorf("expected %v got %v", ErrPhotoRejected, err)
+	}
+	if e := httpError(err, ErrInternal); e.Status != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d got %d", http.StatusUnprocessableEntity, e.Status)
+	}
+	u, err := pm.Usage(pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Photos != 0 {
+		t.Errorf("expected the rejected photo not to be saved, got %d photos", u.Photos)
+	}
+
+	pm.Scanner = ScannerFunc(func(*Photo, io.Reader) (*Verdict, error) {
+		return nil, errors.New("connection refused")
+	})
+	_, err = saveTestPhoto(pm, pids[0], t)
+	if !errors.Is(err, ErrScanFailed) {
+		t.Errorf("expected %v got %v", ErrScanFailed, err)
+	}
+
+	pm.Scanner = verdict(StatusApproved, "")
+	photo, err := saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Status != StatusApproved || !photo.Approved() {
+		t.Errorf("expected the photo to be approved got %q", photo.Status)
+	}
+
+	pm.Scanner = verdict(StatusPending, "")
+	photo, err = saveTestPhoto(pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Approved() {
+		t.Error("expected the photo to be pending")
+	}
+	photo, err = pm.SetStatus(photo.ID, &Verdict{Status: StatusRejected, Reason: "nudity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := pm.Get(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusRejected || stored.StatusReason != "nudity" {
+		t.Errorf("expected the photo to be rejected got %q %q", stored.Status, stored.StatusReason)
+	}
+	if _, err = pm.SetStatus(photo.ID, &Verdict{Status: "maybe"}); err == nil {
+		t.Error("expected an error for an unknown status")
+	}
+	if _, err = pm.SetStatus("nope", &Verdict{Status: StatusApproved}); !errors.Is(err, ErrPhotoNotFound) {
+		t.Errorf("expected %v got %v", ErrPhotoNotFound, err)
+	}
+}
+
+func TestHandlers_PhotoPending(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.pm.Scanner = verdict(StatusPending, "")
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	defer cleanUp()
+	photo, err := saveTestPhoto(handle.pm, pids[0], t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.Picture = photo.ID
+	err = profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(user string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/photo/%s", photo.ID), nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := get(""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d actual %d", http.StatusNotFound, w.Code)
+	}
+	if w := get(pids[1]); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d actual %d", http.StatusNotFound, w.Code)
+	}
+	w := get(pids[0])
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+	if c := w.Header().Get("Cache-Control"); c != "private, no-cache" {
+		t.Errorf("expected a private Cache-Control got %q", c)
+	}
+
+	_, err = handle.pm.SetStatus(photo.ID, &Verdict{Status: StatusApproved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get(""); w.Code != http.StatusOK {
+		t.Errorf("expected %d actual %d", http.StatusOK, w.Code)
+	}
+}
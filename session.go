@@ -0,0 +1,250 @@
+package mrs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionName   = "mrs_session"
+	defaultSessionMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidSession is returned when the session cookie has been tampered with,
+	// or was signed with a key which is no longer known.
+	ErrInvalidSession = errors.New("mrs: invalid session")
+
+	// ErrSessionExpired is returned when the session cookie is past its expiry.
+	ErrSessionExpired = errors.New("mrs: session expired")
+)
+
+// Session is the data kept in the session cookie.
+type Session struct {
+	UserID    string    `json:"uid"`
+	Roles     []string  `json:"roles,omitempty"`
+	CSRFToken string    `json:"csrf"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// Identity returns the identity of the session owner.
+func (s *Session) Identity() *Identity {
+	return &Identity{UserID: s.UserID, Roles: s.Roles}
+}
+
+// Sessions manages sessions stored in signed cookies, it is meant for small
+// deployments which don't have a separate authentication service. Sessions
+// implements Authenticator, so it can be used as the Auth of the Handlers.
+//
+// Cookies are signed with hmac-sha256 and, if Encrypt is true, also encrypted with
+// aes-gcm so that their content can't be read by clients. Keys can be rotated by
+// adding the new key in front of the list, new cookies are always sealed with the
+// first key, and the rest are only used to open cookies issued before the rotation.
+type Sessions struct {
+	keys []sessionKey
+
+	// Name is the name of the cookie.
+	Name string
+
+	// MaxAge is how long a session is valid after being issued.
+	MaxAge time.Duration
+
+	// Encrypt enables encryption of the cookie content.
+	Encrypt bool
+
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// sessionKey holds the keys derived from one of the secrets given to NewSessions, so
+// the same secret is never used for both signing and encryption.
+type sessionKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// NewSessions returns Sessions using the given secret keys, the first one is the
+// current key. The keys should be at least 32 bytes of random data.
+func NewSessions(keys ...[]byte) *Sessions {
+	s := &Sessions{
+		Name:     defaultSessionName,
+		MaxAge:   defaultSessionMaxAge,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	for _, k := range keys {
+		s.keys = append(s.keys, deriveSessionKey(k))
+	}
+	return s
+}
+
+func deriveSessionKey(secret []byte) sessionKey {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("mrs session encryption"))
+	if err != nil {
+		// the key is always 32 bytes long.
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return sessionKey{mac: derive("mrs session signature"), aead: aead}
+}
+
+// Issue starts a session by setting the session cookie on the response. The IssuedAt
+// and ExpiresAt fields are set, and a CSRF token is generated if the session has none.
+func (s *Sessions) Issue(w http.ResponseWriter, sess *Session) error {
+	if len(s.keys) == 0 {
+		return errors.New("mrs: no session keys")
+	}
+	if sess.CSRFToken == "" {
+		token, err := randomToken()
+		if err != nil {
+			return err
+		}
+		sess.CSRFToken = token
+	}
+	sess.IssuedAt = time.Now()
+	sess.ExpiresAt = sess.IssuedAt.Add(s.MaxAge)
+	value, err := s.seal(sess)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, s.cookie(value, int(s.MaxAge/time.Second)))
+	return nil
+}
+
+// Clear ends the session by removing the cookie.
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie("", -1))
+}
+
+// Load returns the session of the request. Requests without the session cookie have a
+// nil session and a nil error.
+func (s *Sessions) Load(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(s.Name)
+	if err != nil {
+		return nil, nil
+	}
+	sess, err := s.open(c.Value)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	return sess, nil
+}
+
+// Authenticate implements Authenticator.
+func (s *Sessions) Authenticate(r *http.Request) (*Identity, error) {
+	sess, err := s.Load(r)
+	if err != nil || sess == nil {
+		return nil, err
+	}
+	return sess.Identity(), nil
+}
+
+func (s *Sessions) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.Name,
+		Value:    value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	}
+}
+
+// seal encodes the session as payload.signature, both base64 encoded. The signature
+// covers the cookie name too, so a value can't be moved to another cookie.
+func (s *Sessions) seal(sess *Session) (string, error) {
+	payload, err := json.Marshal(sess)
+	if err != nil {
+		return "", err
+	}
+	key := s.keys[0]
+	if s.Encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		_, err = io.ReadFull(rand.Reader, nonce)
+		if err != nil {
+			return "", err
+		}
+		payload = key.aead.Seal(nonce, nonce, payload, []byte(s.Name))
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + s.sign(key, enc), nil
+}
+
+func (s *Sessions) open(value string) (*Session, error) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return nil, ErrInvalidSession
+	}
+	enc, sig := value[:i], value[i+1:]
+	for _, key := range s.keys {
+		if !hmac.Equal([]byte(sig), []byte(s.sign(key, enc))) {
+			continue
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, ErrInvalidSession
+		}
+		if s.Encrypt {
+			n := key.aead.NonceSize()
+			if len(payload) < n {
+				return nil, ErrInvalidSession
+			}
+			payload, err = key.aead.Open(nil, payload[:n], payload[n:], []byte(s.Name))
+			if err != nil {
+				return nil, ErrInvalidSession
+			}
+		}
+		sess := new(Session)
+		err = json.Unmarshal(payload, sess)
+		if err != nil {
+			return nil, ErrInvalidSession
+		}
+		return sess, nil
+	}
+	return nil, ErrInvalidSession
+}
+
+func (s *Sessions) sign(key sessionKey, data string) string {
+	mac := hmac.New(sha256.New, key.mac)
+	mac.Write([]byte(s.Name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomToken returns 32 bytes of random data, base64 encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,131 @@
+package mrs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+var (
+	oldKey = []byte("0123456789abcdef0123456789abcdef")
+	newKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// issue returns a request carrying the session cookie issued by s.
+func issue(s *Sessions, sess *Session, t *testing.T) *http.Request {
+	w := httptest.NewRecorder()
+	err := s.Issue(w, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessions(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		s := NewSessions(oldKey)
+		s.Encrypt = encrypt
+		r := issue(s, &Session{UserID: pids[0], Roles: []string{RoleAdmin}}, t)
+		c, _ := r.Cookie(s.Name)
+		payload, _ := base64.RawURLEncoding.DecodeString(c.Value[:strings.LastIndex(c.Value, ".")])
+		if encrypt == strings.Contains(string(payload), pids[0]) {
+			t.Errorf("encrypt=%v: unexpected cookie payload %s", encrypt, payload)
+		}
+
+		sess, err := s.Load(r)
+		if err != nil {
+			t.Fatalf("encrypt=%v: %v", encrypt, err)
+		}
+		if sess.UserID != pids[0] || sess.CSRFToken == "" {
+			t.Errorf("encrypt=%v: unexpected session %+v", encrypt, sess)
+		}
+		who, err := s.Authenticate(r)
+		if err != nil || !who.HasRole(RoleAdmin) {
+			t.Errorf("encrypt=%v: unexpected identity %+v %v", encrypt, who, err)
+		}
+
+		// tampering
+		r, _ = http.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: s.Name, Value: "x" + c.Value})
+		if _, err = s.Load(r); err != ErrInvalidSession {
+			t.Errorf("encrypt=%v: expected %v actual %v", encrypt, ErrInvalidSession, err)
+		}
+
+		// rotation, the old key is still accepted but not the other way around.
+		rotated := NewSessions(newKey, oldKey)
+		rotated.Encrypt = encrypt
+		r, _ = http.NewRequest("GET", "/", nil)
+		r.AddCookie(c)
+		if _, err = rotated.Load(r); err != nil {
+			t.Errorf("encrypt=%v: %v", encrypt, err)
+		}
+		r = issue(rotated, &Session{UserID: pids[0]}, t)
+		if _, err = s.Load(r); err != ErrInvalidSession {
+			t.Errorf("encrypt=%v: expected %v actual %v", encrypt, ErrInvalidSession, err)
+		}
+	}
+
+	// expiry
+	s := NewSessions(oldKey)
+	value, err := s.seal(&Session{UserID: pids[0], ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: s.Name, Value: value})
+	if _, err = s.Load(r); err != ErrSessionExpired {
+		t.Errorf("Expected %v actual %v", ErrSessionExpired, err)
+	}
+
+	// anonymous
+	r, _ = http.NewRequest("GET", "/", nil)
+	if who, err := s.Authenticate(r); who != nil || err != nil {
+		t.Errorf("Expected anonymous got %v %v", who, err)
+	}
+}
+
+func TestHandlers_Sessions(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	sessions := NewSessions(oldKey)
+	handle.Auth = sessions
+
+	h := mux.NewRouter()
+	h.HandleFunc("/profile/update/{id}", handle.Update)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := issue(sessions, &Session{UserID: pids[0]}, t)
+	r, _ := http.NewRequest("PUT", fmt.Sprintf("/profile/update/%s", pids[0]), strings.NewReader(`{"city":"mwanza"}`))
+	r.Header.Set("If-Match", "*")
+	r.Header.Set("Cookie", login.Header.Get("Cookie"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d actual %d", http.StatusOK, w.Code)
+	}
+
+	r, _ = http.NewRequest("PUT", fmt.Sprintf("/profile/update/%s", pids[0]), strings.NewReader(`{"city":"mwanza"}`))
+	r.Header.Set("If-Match", "*")
+	r.AddCookie(&http.Cookie{Name: sessions.Name, Value: "forged.cookie"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d actual %d", http.StatusUnauthorized, w.Code)
+	}
+}
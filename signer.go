@@ -0,0 +1,127 @@
+package mrs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenditionOriginal is the rendition of a photo as it was uploaded.
+const RenditionOriginal = "original"
+
+var (
+	// ErrInvalidSignature is the message when a signed photo url has been tampered
+	// with, or is used by someone else than the viewer it was issued for.
+	ErrInvalidSignature = errors.New("sorry: invalid photo link")
+
+	// ErrLinkExpired is the message when a signed photo url is past its expiry.
+	ErrLinkExpired = errors.New("sorry: the photo link has expired")
+
+	// ErrPhotoPrivate is the message when a photo is requested by someone who isn't
+	// allowed to see it, without a signed url.
+	ErrPhotoPrivate = errors.New("sorry: this photo is private")
+)
+
+// URLSigner produces signed, time limited links to photos, so that private photos can
+// be shared without being made public. A link is for a photo id and rendition, and can
+// be bound to a viewer in which case it only works for that user.
+//
+// Links are signed with hmac-sha256. Keys can be rotated the same way as with Sessions,
+// new links are signed with the first key and the rest are only used for verification.
+type URLSigner struct {
+	keys [][]byte
+}
+
+// NewURLSigner returns a URLSigner using the given secret keys, the first one is the
+// current key. The keys should be at least 32 bytes of random data.
+func NewURLSigner(keys ...[]byte) *URLSigner {
+	s := &URLSigner{}
+	for _, k := range keys {
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte("mrs photo url signature"))
+		s.keys = append(s.keys, mac.Sum(nil))
+	}
+	return s
+}
+
+// Sign returns the query parameters granting access to the rendition of the photo
+// until expires. If viewer is not empty the link only works for the user with that id,
+// the rendition defaults to RenditionOriginal. It panics if the signer has no keys.
+func (s *URLSigner) Sign(photoID, rendition, viewer string, expires time.Time) url.Values {
+	if rendition == "" {
+		rendition = RenditionOriginal
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("rendition", rendition)
+	q.Set("expires", exp)
+	if viewer != "" {
+		q.Set("viewer", viewer)
+	}
+	q.Set("sig", s.sign(s.keys[0], photoID, rendition, exp, viewer))
+	return q
+}
+
+// SignURL appends the signed query for the photo to base, which is the url the Photo
+// handler is served at. The link is valid for ttl.
+func (s *URLSigner) SignURL(base, photoID, rendition, viewer string, ttl time.Duration) (string, error) {
+	if len(s.keys) == 0 {
+		return "", errors.New("mrs: no url signing keys")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range s.Sign(photoID, rendition, viewer, time.Now().Add(ttl)) {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Signed reports whether the query carries a signature.
+func Signed(q url.Values) bool {
+	return q.Get("sig") != ""
+}
+
+// Verify checks the signed query of a link to the photo. The viewer is the id of the
+// user following the link, it only matters for links bound to a viewer. It returns the
+// rendition and expiry of the link.
+func (s *URLSigner) Verify(q url.Values, photoID, viewer string) (string, time.Time, error) {
+	rendition, exp, bound := q.Get("rendition"), q.Get("expires"), q.Get("viewer")
+	sig := q.Get("sig")
+	if rendition == "" || exp == "" || sig == "" {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	valid := false
+	for _, key := range s.keys {
+		if hmac.Equal([]byte(sig), []byte(s.sign(key, photoID, rendition, exp, bound))) {
+			valid = true
+			break
+		}
+	}
+	if !valid || (bound != "" && bound != viewer) {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	sec, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	expires := time.Unix(sec, 0)
+	if time.Now().After(expires) {
+		return "", time.Time{}, ErrLinkExpired
+	}
+	return rendition, expires, nil
+}
+
+func (s *URLSigner) sign(key []byte, parts ...string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,131 @@
+package mrs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func TestURLSigner(t *testing.T) {
+	s := NewURLSigner(newKey, oldKey)
+	old := NewURLSigner(oldKey)
+	hour := time.Now().Add(time.Hour)
+
+	q := s.Sign("photo", "", "", hour)
+	rendition, expires, err := s.Verify(q, "photo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendition != RenditionOriginal {
+		t.Errorf("expected %s got %s", RenditionOriginal, rendition)
+	}
+	if expires.Unix() != hour.Unix() {
+		t.Errorf("expected %v got %v", hour, expires)
+	}
+
+	tampered := func(q url.Values, k, v string) url.Values {
+		c := url.Values{}
+		for key, val := range q {
+			c[key] = val
+		}
+		c.Set(k, v)
+		return c
+	}
+	sample := []struct {
+		name   string
+		q      url.Values
+		photo  string
+		viewer string
+		err    error
+	}{
+		{"other photo", q, "other", "", ErrInvalidSignature},
+		{"rendition", tampered(q, "rendition", "square"), "photo", "", ErrInvalidSignature},
+		{"expiry", tampered(q, "expires", "9999999999"), "photo", "", ErrInvalidSignature},
+		{"no signature", tampered(q, "sig", ""), "photo", "", ErrInvalidSignature},
+		{"expired", s.Sign("photo", "", "", time.Now().Add(-time.Minute)), "photo", "", ErrLinkExpired},
+		{"bound anonymous", s.Sign("photo", "", "bob", hour), "photo", "", ErrInvalidSignature},
+		{"bound other", s.Sign("photo", "", "bob", hour), "photo", "alice", ErrInvalidSignature},
+		{"bound", s.Sign("photo", "", "bob", hour), "photo", "bob", nil},
+		{"rotated", old.Sign("photo", "", "", hour), "photo", "", nil},
+	}
+	for _, v := range sample {
+		_, _, err := s.Verify(v.q, v.photo, v.viewer)
+		if err != v.err {
+			t.Errorf("%s: expected %v got %v", v.name, v.err, err)
+		}
+	}
+	if _, _, err := old.Verify(q, "photo", ""); err != ErrInvalidSignature {
+		t.Errorf("expected %v got %v", ErrInvalidSignature, err)
+	}
+}
+
+func TestHandlers_PhotoPrivate(t *testing.T) {
+	opts := render.Options{Directory: "fixture"}
+	handle := NewHandlers("imgs.db", "meta", "data", &opts)
+	handle.Auth = headerAuth
+	handle.Signer = NewURLSigner(newKey)
+	defer handle.pm.store.DeleteDatabase()
+
+	h := mux.NewRouter()
+	h.HandleFunc("/photo/{id}", handle.Photo)
+
+	defer cleanUp()
+	profile := NewProfile(MustParseProfileID(pids[0]))
+	profile.Privacy = map[string]Visibility{"photos": Friends}
+	err := profile.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := requestWithFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := handle.pm.GetSingleFileUpload(req, "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := handle.pm.SaveSingle(up, pids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/photo/%s", photo.ID)
+	signed, err := handle.Signer.SignURL(path, photo.ID, "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, err := handle.Signer.SignURL(path, photo.ID, "", pids[1], time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []struct {
+		path, user string
+		code       int
+	}{
+		{path, "", http.StatusForbidden},
+		{path, pids[1], http.StatusForbidden},
+		{path, pids[0], http.StatusOK},
+		{signed, "", http.StatusOK},
+		{bound, "", http.StatusForbidden},
+		{bound, pids[1], http.StatusOK},
+		{path + "?sig=bad&expires=1&rendition=original", "", http.StatusForbidden},
+	}
+	for _, v := range sample {
+		r, _ := http.NewRequest("GET", v.path, nil)
+		r.Header.Set("X-User", v.user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s %q: expected %d actual %d", v.path, v.user, v.code, w.Code)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("Cache-Control") == DefaultCachePolicy.Photo {
+			t.Errorf("%s %q: expected a private Cache-Control", v.path, v.user)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package mrs
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// storeTimeout is how long opening a database waits for the lock held by another
+// handle, after which the operation fails instead of hanging.
+const storeTimeout = 10 * time.Second
+
+// update opens the bolt database at path and runs fn inside a read-write
+// transaction. Like nutz, the database is closed as soon as fn returns, this way
+// it plays nicely with the nutz.Storage objects which share the same files.
+func update(path string, fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: storeTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+// view is like update but runs fn inside a read-only transaction. The database is
+// opened in read-only mode, so several views can be running at the same time.
+func view(path string, fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: storeTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}